@@ -0,0 +1,57 @@
+package completion
+
+import (
+	"flag"
+)
+
+// A FlagCompleterBuilder constructs a flag-aware Completer, as
+// CompleterWithFlagValues does, one flag at a time. For example:
+//
+//	completer := NewFlagCompleter(flags).
+//		For("output", FileCompleter()).
+//		For("level", SetCompleter(levels)).
+//		Positional(DirCompleter()).
+//		Build()
+type FlagCompleterBuilder struct {
+	flags  *flag.FlagSet
+	values map[string]Completer
+	inner  Completer
+}
+
+// NewFlagCompleter returns a FlagCompleterBuilder for the flags in
+// flags. Until values are registered with For, flag values are not
+// completed, and until a Completer is registered with Positional,
+// positional arguments are not completed.
+func NewFlagCompleter(flags *flag.FlagSet) *FlagCompleterBuilder {
+	return &FlagCompleterBuilder{
+		flags:  flags,
+		values: make(map[string]Completer),
+	}
+}
+
+// For registers completer to complete the values of the flag named
+// name.
+func (b *FlagCompleterBuilder) For(name string, completer Completer) *FlagCompleterBuilder {
+	b.values[name] = completer
+	return b
+}
+
+// Positional registers completer to complete positional arguments.
+func (b *FlagCompleterBuilder) Positional(completer Completer) *FlagCompleterBuilder {
+	b.inner = completer
+	return b
+}
+
+// Build returns the Completer. Further changes to the builder don't
+// affect Completers already built.
+func (b *FlagCompleterBuilder) Build() Completer {
+	values := make(map[string]Completer, len(b.values))
+	for name, completer := range b.values {
+		values[name] = completer
+	}
+	inner := b.inner
+	if inner == nil {
+		inner = SetCompleter(nil)
+	}
+	return CompleterWithFlagValues(b.flags, values, inner)
+}
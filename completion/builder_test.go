@@ -0,0 +1,44 @@
+package completion
+
+import (
+	"flag"
+	. "launchpad.net/gocheck"
+)
+
+type BuilderSuite struct{}
+
+var _ = Suite(&BuilderSuite{})
+
+func (s *BuilderSuite) TestBuilder(c *C) {
+	flags := flag.NewFlagSet("builder", flag.ContinueOnError)
+	flags.String("level", "", "")
+	flags.String("output", "", "")
+	levels := SetCompleter([]string{"debug", "info"})
+	positional := SetCompleter([]string{"build", "test"})
+
+	b := NewFlagCompleter(flags).
+		For("level", levels).
+		Positional(positional)
+	built := b.Build()
+	mapped := CompleterWithFlagValues(flags, map[string]Completer{"level": levels}, positional)
+
+	for _, cl := range []CommandLine{
+		{""},
+		{"-"},
+		{"-level", "d"},
+		{"--level=i"},
+		{"-level", "info", "t"},
+		{"-output", ""},
+	} {
+		c.Check(built.Complete(cl), DeepEquals, mapped.Complete(cl),
+			Commentf("command line: %q", cl))
+	}
+
+	b.For("output", SetCompleter([]string{"out.txt"}))
+	c.Check(built.Complete(CommandLine{"-output", ""}), DeepEquals, []string{})
+	c.Check(b.Build().Complete(CommandLine{"-output", ""}), DeepEquals, []string{"out.txt"})
+
+	bare := NewFlagCompleter(flags).Build()
+	c.Check(bare.Complete(CommandLine{"x"}), IsNil)
+	c.Check(bare.Complete(CommandLine{"-l"}), DeepEquals, []string{"-level"})
+}
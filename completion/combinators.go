@@ -0,0 +1,214 @@
+package completion
+
+import (
+	"strings"
+	"sync"
+)
+
+type stripCompleter struct {
+	inner Completer
+	n     int
+}
+
+// StripCompleter returns a Completer that drops the first n words of
+// the CommandLine before delegating to inner, so that completers for
+// nested commands can be written as if their arguments start at
+// position 0. The word being completed is never dropped: if the
+// CommandLine has n or fewer words, inner sees a CommandLine
+// consisting of just the current word.
+func StripCompleter(inner Completer, n int) Completer {
+	return &stripCompleter{inner: inner, n: n}
+}
+
+func (c *stripCompleter) Complete(cl CommandLine) []string {
+	if c.n >= len(cl) {
+		return c.inner.Complete(CommandLine{cl.CurrentWord()})
+	}
+	if c.n > 0 {
+		cl = cl[c.n:]
+	}
+	return c.inner.Complete(cl)
+}
+
+type noCompleter struct{}
+
+func (noCompleter) Complete(cl CommandLine) []string {
+	Signal(NoFileCompletion)
+	return []string{}
+}
+
+// NoCompletion returns a Completer that completes nothing, and that
+// prevents the shell from falling back to completing file names in
+// the absence of completions.
+func NoCompletion() Completer {
+	return noCompleter{}
+}
+
+type chainCompleter []Completer
+
+func (c chainCompleter) Complete(cl CommandLine) (completions []string) {
+	for _, completer := range c {
+		completions = append(completions, completer.Complete(cl)...)
+	}
+	return completions
+}
+
+// ChainCompleter returns a Completer that returns the completions of
+// each of the given Completers, in order.
+func ChainCompleter(completers ...Completer) Completer {
+	return chainCompleter(completers)
+}
+
+type firstCompleter []Completer
+
+func (c firstCompleter) Complete(cl CommandLine) []string {
+	for _, completer := range c {
+		if completions := completer.Complete(cl); len(completions) > 0 {
+			return completions
+		}
+	}
+	return nil
+}
+
+// FirstCompleter returns a Completer that returns the completions of
+// the first of the given Completers to return any. The remaining
+// Completers are not invoked. This is useful to prefer specific
+// completions, falling back to more generic ones (such as file
+// names) only if there are none.
+func FirstCompleter(completers ...Completer) Completer {
+	return firstCompleter(completers)
+}
+
+type budgetedChain struct {
+	max        int
+	completers []Completer
+}
+
+func (c budgetedChain) Complete(cl CommandLine) (completions []string) {
+	for _, completer := range c.completers {
+		if len(completions) >= c.max {
+			break
+		}
+		more := completer.Complete(cl)
+		if n := c.max - len(completions); len(more) > n {
+			more = more[:n]
+		}
+		completions = append(completions, more...)
+	}
+	return completions
+}
+
+// BudgetedChain is like ChainCompleter, but bounds the total number of
+// completions returned by the Completers to max. The Completers are
+// invoked in order until max completions have been collected; the
+// completions of the last one invoked are truncated as necessary,
+// and the remaining Completers are not invoked at all.
+func BudgetedChain(max int, completers ...Completer) Completer {
+	return budgetedChain{max, completers}
+}
+
+type prefixRouter struct {
+	routes   map[string]Completer
+	fallback Completer
+}
+
+func (c prefixRouter) Complete(cl CommandLine) []string {
+	word := cl.CurrentWord()
+	completer, longest := c.fallback, -1
+	for prefix, route := range c.routes {
+		if strings.HasPrefix(word, prefix) && len(prefix) > longest {
+			completer, longest = route, len(prefix)
+		}
+	}
+	if completer == nil {
+		return nil
+	}
+	return completer.Complete(cl)
+}
+
+// PrefixRouter returns a Completer that dispatches on the prefix of
+// the word being completed, such as `@' for a file and `#' for a tag.
+// The Completer in routes for the longest prefix of the word is
+// invoked; if no prefix matches, fallback is, if it isn't nil. The
+// word is passed on unchanged, prefix included, so that the chosen
+// Completer can keep the prefix on its completions.
+func PrefixRouter(routes map[string]Completer, fallback Completer) Completer {
+	return prefixRouter{routes, fallback}
+}
+
+type suffixCompleter struct {
+	inner  Completer
+	suffix string
+}
+
+func (c suffixCompleter) Complete(cl CommandLine) (completions []string) {
+	for _, completion := range c.inner.Complete(cl) {
+		word, description := splitDescription(completion)
+		switch {
+		case word == "":
+			// A Hint has no completion to add the suffix to.
+		case description != "":
+			completion = Describe(word+c.suffix, description)
+		default:
+			completion = word + c.suffix
+		}
+		completions = append(completions, completion)
+	}
+	return completions
+}
+
+// SuffixCompleter returns a Completer that appends suffix to each of
+// the completions of inner, such as "=" to complete `json' to
+// `json=', ready for the user to type a value. Since the user will
+// usually continue typing after the suffix, it is typically used
+// with a Completer that signals NoSpace. The suffix is added to the
+// completion itself, not to its description (see Describe), and not
+// to hints.
+func SuffixCompleter(inner Completer, suffix string) Completer {
+	return suffixCompleter{inner, suffix}
+}
+
+// A memoResult is a result cached by Memoize.
+type memoResult struct {
+	completions []string
+	directives  Directive
+}
+
+type memoCompleter struct {
+	inner Completer
+	mu    sync.Mutex
+	cache map[string]memoResult
+}
+
+func (c *memoCompleter) Complete(cl CommandLine) []string {
+	key := strings.Join(cl, "\x00")
+	c.mu.Lock()
+	r, ok := c.cache[key]
+	c.mu.Unlock()
+	if !ok {
+		before := currentDirectives()
+		r.completions = c.inner.Complete(cl)
+		r.directives = currentDirectives() &^ before
+		c.mu.Lock()
+		c.cache[key] = r
+		c.mu.Unlock()
+	}
+	Signal(r.directives)
+	if r.completions == nil {
+		return nil
+	}
+	return append([]string{}, r.completions...)
+}
+
+// Memoize returns a Completer that caches the completions of inner
+// for each CommandLine, so that inner is invoked only once for a
+// given CommandLine however many times the Completer is. This is
+// useful when an expensive Completer is shared by several
+// combinators that may each invoke it during a single completion.
+// The directives signaled by inner are signaled again whenever a
+// cached result is returned. The cache lasts as long as the
+// Completer, which is ordinarily the lifetime of the process
+// performing the completion.
+func Memoize(inner Completer) Completer {
+	return &memoCompleter{inner: inner, cache: make(map[string]memoResult)}
+}
@@ -0,0 +1,161 @@
+package completion
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type CombinatorSuite struct{}
+
+var _ = Suite(&CombinatorSuite{})
+
+func echoCompleter() Completer {
+	return FunctionCompleter(func(cl CommandLine) []string {
+		return []string(cl)
+	})
+}
+
+func (s *CombinatorSuite) TestStripCompleter(c *C) {
+	testCases := []struct {
+		n     int
+		line  []string
+		words []string
+	}{
+		{0, []string{"a", "b", "c"}, []string{"a", "b", "c"}},
+		{1, []string{"a", "b", "c"}, []string{"b", "c"}},
+		{2, []string{"a", "b", "c"}, []string{"c"}},
+		{3, []string{"a", "b", "c"}, []string{"c"}},
+		{10, []string{"a", ""}, []string{""}},
+		{-1, []string{"a", "b"}, []string{"a", "b"}},
+	}
+	for _, tc := range testCases {
+		words := StripCompleter(echoCompleter(), tc.n).Complete(tc.line)
+		c.Check(words, DeepEquals, tc.words)
+	}
+}
+
+func (s *CombinatorSuite) TestNoCompletion(c *C) {
+	resetDirectives()
+	c.Check(NoCompletion().Complete(CommandLine{"x"}), DeepEquals, []string{})
+	c.Check(currentDirectives(), Equals, NoFileCompletion)
+}
+
+// countingCompleter records how many times it is invoked.
+type countingCompleter struct {
+	calls       int
+	completions []string
+}
+
+func (c *countingCompleter) Complete(cl CommandLine) []string {
+	c.calls++
+	return c.completions
+}
+
+func (s *CombinatorSuite) TestChainCompleter(c *C) {
+	a := SetCompleter([]string{"apple", "banana"})
+	b := SetCompleter([]string{"avocado", "cherry"})
+	c.Check(ChainCompleter(a, b).Complete(CommandLine{"a"}), DeepEquals, []string{"apple", "avocado"})
+	c.Check(ChainCompleter(a, b).Complete(CommandLine{"x"}), IsNil)
+	c.Check(ChainCompleter().Complete(CommandLine{""}), IsNil)
+}
+
+func (s *CombinatorSuite) TestFirstCompleter(c *C) {
+	none := &countingCompleter{}
+	empty := &countingCompleter{completions: []string{}}
+	some := &countingCompleter{completions: []string{"x", "y"}}
+	later := &countingCompleter{completions: []string{"z"}}
+
+	completer := FirstCompleter(none, empty, some, later)
+	c.Check(completer.Complete(CommandLine{""}), DeepEquals, []string{"x", "y"})
+	c.Check(none.calls, Equals, 1)
+	c.Check(empty.calls, Equals, 1)
+	c.Check(some.calls, Equals, 1)
+	c.Check(later.calls, Equals, 0)
+
+	c.Check(FirstCompleter(none, empty).Complete(CommandLine{""}), IsNil)
+	c.Check(FirstCompleter().Complete(CommandLine{""}), IsNil)
+}
+
+func (s *CombinatorSuite) TestBudgetedChain(c *C) {
+	a := &countingCompleter{completions: []string{"a1", "a2"}}
+	b := &countingCompleter{completions: []string{"b1", "b2", "b3"}}
+	d := &countingCompleter{completions: []string{"d1"}}
+
+	c.Check(BudgetedChain(10, a, b, d).Complete(CommandLine{""}), DeepEquals,
+		[]string{"a1", "a2", "b1", "b2", "b3", "d1"})
+	c.Check(BudgetedChain(4, a, b, d).Complete(CommandLine{""}), DeepEquals,
+		[]string{"a1", "a2", "b1", "b2"})
+	c.Check(d.calls, Equals, 1)
+	c.Check(BudgetedChain(2, a, b, d).Complete(CommandLine{""}), DeepEquals, []string{"a1", "a2"})
+	c.Check(b.calls, Equals, 2)
+	c.Check(BudgetedChain(0, a).Complete(CommandLine{""}), IsNil)
+	c.Check(a.calls, Equals, 3)
+}
+
+func (s *CombinatorSuite) TestPrefixRouter(c *C) {
+	router := PrefixRouter(map[string]Completer{
+		"@":  SetCompleter([]string{"@file.txt", "@files/"}),
+		"#":  SetCompleter([]string{"#bug", "#feature"}),
+		"#!": SetCompleter([]string{"#!urgent"}),
+	}, SetCompleter([]string{"build", "#literal"}))
+
+	c.Check(router.Complete(CommandLine{"@f"}), DeepEquals, []string{"@file.txt", "@files/"})
+	c.Check(router.Complete(CommandLine{"#"}), DeepEquals, []string{"#bug", "#feature"})
+	c.Check(router.Complete(CommandLine{"#!"}), DeepEquals, []string{"#!urgent"})
+	c.Check(router.Complete(CommandLine{"b"}), DeepEquals, []string{"build"})
+	c.Check(router.Complete(CommandLine{""}), DeepEquals, []string{"build", "#literal"})
+
+	router = PrefixRouter(map[string]Completer{"@": echoCompleter()}, nil)
+	c.Check(router.Complete(CommandLine{"x", "@a"}), DeepEquals, []string{"x", "@a"})
+	c.Check(router.Complete(CommandLine{"a"}), IsNil)
+}
+
+func (s *CombinatorSuite) TestSuffixCompleter(c *C) {
+	formats := []string{"json", "yaml"}
+	completer := SuffixCompleter(SetCompleter(formats), "=")
+	c.Check(completer.Complete(CommandLine{""}), DeepEquals, []string{"json=", "yaml="})
+	c.Check(completer.Complete(CommandLine{"j"}), DeepEquals, []string{"json="})
+	c.Check(completer.Complete(CommandLine{"x"}), IsNil)
+
+	// The inner Completer's completions are left alone.
+	completer = SuffixCompleter(FunctionCompleter(func(CommandLine) []string { return formats }), ":")
+	c.Check(completer.Complete(CommandLine{""}), DeepEquals, []string{"json:", "yaml:"})
+	c.Check(formats, DeepEquals, []string{"json", "yaml"})
+
+	completer = SuffixCompleter(FunctionCompleter(func(CommandLine) []string {
+		return []string{Describe("json", "JSON output"), "yaml", Hint("format")}
+	}), "=")
+	c.Check(completer.Complete(CommandLine{""}), DeepEquals,
+		[]string{Describe("json=", "JSON output"), "yaml=", Hint("format")})
+}
+
+func (s *CombinatorSuite) TestMemoize(c *C) {
+	calls := 0
+	memo := Memoize(FunctionCompleter(func(cl CommandLine) []string {
+		calls++
+		Signal(NoSpace)
+		if cl.CurrentWord() == "x" {
+			return nil
+		}
+		return []string{cl.CurrentWord() + "1", cl.CurrentWord() + "2"}
+	}))
+
+	completer := ChainCompleter(memo, memo)
+	resetDirectives()
+	c.Check(completer.Complete(CommandLine{"a"}), DeepEquals, []string{"a1", "a2", "a1", "a2"})
+	c.Check(calls, Equals, 1)
+	c.Check(currentDirectives(), Equals, NoSpace)
+
+	resetDirectives()
+	completions := memo.Complete(CommandLine{"a"})
+	c.Check(calls, Equals, 1)
+	c.Check(currentDirectives(), Equals, NoSpace)
+	completions[0] = "changed"
+	c.Check(memo.Complete(CommandLine{"a"}), DeepEquals, []string{"a1", "a2"})
+
+	c.Check(memo.Complete(CommandLine{"b", "a"}), DeepEquals, []string{"a1", "a2"})
+	c.Check(memo.Complete(CommandLine{"b a"}), DeepEquals, []string{"b a1", "b a2"})
+	c.Check(calls, Equals, 3)
+	c.Check(memo.Complete(CommandLine{"x"}), IsNil)
+	c.Check(memo.Complete(CommandLine{"x"}), IsNil)
+	c.Check(calls, Equals, 4)
+}
@@ -0,0 +1,87 @@
+package completion
+
+import (
+	"flag"
+	"strings"
+)
+
+// A Command describes a command in a tree of subcommands, such as
+// `tool remote add', for CommandCompleter.
+type Command struct {
+	// Name is the name by which the command is invoked. It is
+	// ignored for the root of the tree.
+	Name string
+	// Short is a one-line description of the command, offered
+	// alongside its name by shells that show descriptions (see
+	// Describe).
+	Short string
+	// Flags are the flags accepted by the command, which precede
+	// its subcommand or arguments; it may be nil. Options
+	// customizes their completion, as for
+	// CompleterWithFlagOptions.
+	Flags   *flag.FlagSet
+	Options FlagOptions
+	// Subcommands are the command's subcommands.
+	Subcommands []*Command
+	// Args, if not nil, completes the command's positional
+	// arguments. The CommandLine it sees starts after the
+	// command's name and flags.
+	Args Completer
+}
+
+// lookup returns the subcommand named name, or nil.
+func (c *Command) lookup(name string) *Command {
+	for _, sub := range c.Subcommands {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+type commandCompleter struct {
+	root *Command
+}
+
+func (c commandCompleter) Complete(cl CommandLine) []string {
+	cmd := c.root
+	for {
+		completions, rest := completeFlags(cl, cmd.Flags, &cmd.Options)
+		if rest == nil {
+			return completions
+		}
+		if len(rest) > 1 {
+			if sub := cmd.lookup(rest[0]); sub != nil {
+				cmd, cl = sub, rest[1:]
+				continue
+			}
+		} else {
+			for _, sub := range cmd.Subcommands {
+				if !strings.HasPrefix(sub.Name, rest.CurrentWord()) {
+					continue
+				}
+				if sub.Short != "" {
+					completions = append(completions, Describe(sub.Name, sub.Short))
+				} else {
+					completions = append(completions, sub.Name)
+				}
+			}
+		}
+		if cmd.Args != nil {
+			completions = append(completions, cmd.Args.Complete(rest)...)
+		}
+		return completions
+	}
+}
+
+// CommandCompleter returns a Completer for a program with a tree of
+// subcommands, rooted at root, which describes the program itself.
+// Words naming a subcommand descend into it; for the word being
+// completed, the current command's subcommands are offered, each
+// with its Short description, along with its flags and the
+// completions of its Args. This way, a self-describing program
+// defines each command's description once, for both its help output
+// and completion.
+func CommandCompleter(root *Command) Completer {
+	return commandCompleter{root}
+}
@@ -0,0 +1,69 @@
+package completion
+
+import (
+	"flag"
+	. "launchpad.net/gocheck"
+)
+
+type CommandSuite struct {
+	root *Command
+}
+
+var _ = Suite(&CommandSuite{})
+
+func (s *CommandSuite) SetUpTest(c *C) {
+	rootFlags := flag.NewFlagSet("tool", flag.ContinueOnError)
+	rootFlags.Bool("verbose", false, "")
+	addFlags := flag.NewFlagSet("add", flag.ContinueOnError)
+	addFlags.String("branch", "", "")
+
+	s.root = &Command{
+		Flags: rootFlags,
+		Subcommands: []*Command{
+			{
+				Name:  "remote",
+				Short: "Manage remotes",
+				Subcommands: []*Command{
+					{Name: "add", Short: "Add a remote", Flags: addFlags,
+						Options: FlagOptions{Values: map[string]Completer{
+							"branch": SetCompleter([]string{"main", "dev"}),
+						}},
+						Args: SetCompleter([]string{"origin", "upstream"})},
+					{Name: "remove", Short: "Remove a remote"},
+				},
+			},
+			{Name: "run", Args: SetCompleter([]string{"build", "test"})},
+			{Name: "rebase", Short: "Rebase the branch"},
+		},
+	}
+}
+
+func (s *CommandSuite) TestCommandCompleter(c *C) {
+	completer := CommandCompleter(s.root)
+	testCases := []struct {
+		commandLine []string
+		completions []string
+	}{
+		{[]string{"r"}, []string{
+			Describe("remote", "Manage remotes"), "run", Describe("rebase", "Rebase the branch"),
+		}},
+		{[]string{""}, []string{
+			"-verbose",
+			Describe("remote", "Manage remotes"), "run", Describe("rebase", "Rebase the branch"),
+		}},
+		{[]string{"-verbose", "rem"}, []string{Describe("remote", "Manage remotes")}},
+		{[]string{"--v"}, []string{"-verbose"}},
+		{[]string{"remote", "a"}, []string{Describe("add", "Add a remote")}},
+		{[]string{"remote", "add", ""}, []string{"-branch", "origin", "upstream"}},
+		{[]string{"remote", "add", "--branch", "d"}, []string{"dev"}},
+		{[]string{"remote", "add", "--branch", "dev", "o"}, []string{"origin"}},
+		{[]string{"remote", "add", "origin", ""}, []string{"origin", "upstream"}},
+		{[]string{"run", "t"}, []string{"test"}},
+		{[]string{"remote", "bogus", ""}, nil},
+		{[]string{"bogus", ""}, nil},
+	}
+	for _, tc := range testCases {
+		c.Check(completer.Complete(tc.commandLine), DeepEquals, tc.completions,
+			Commentf("command line: %q", tc.commandLine))
+	}
+}
@@ -10,12 +10,44 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// completionLog reports problems with completion on standard error.
+// Its messages are always plain text, since standard error is often
+// captured along with the completions parsed by the shell; styled
+// diagnostics should go through styled instead.
 var completionLog = log.New(os.Stderr, "completion: ", log.LstdFlags)
 
+// isTerminal reports whether f is a terminal; tests replace it.
+var isTerminal = func(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorDiagnostics reports whether diagnostics written to standard
+// error may be styled with ANSI escape sequences: only if standard
+// error is a terminal and $NO_COLOR is unset or empty (see
+// https://no-color.org).
+func colorDiagnostics() bool {
+	return os.Getenv("NO_COLOR") == "" && isTerminal(os.Stderr)
+}
+
+// styled wraps s in the ANSI SGR escape sequence sgr, such as "1" for
+// bold, if diagnostics may be styled, and returns it unchanged
+// otherwise. It is the hook for styled diagnostics written directly
+// to a terminal; nothing logged through completionLog uses it.
+func styled(sgr, s string) string {
+	if !colorDiagnostics() {
+		return s
+	}
+	return "\x1b[" + sgr + "m" + s + "\x1b[0m"
+}
+
 // A CommandLine represents a parsed command-line that is being
 // tab-completed. A CommandLine consists of only the words up to and
 // including the word being completed -- The cursor is always
@@ -23,6 +55,11 @@ var completionLog = log.New(os.Stderr, "completion: ", log.LstdFlags)
 //
 // By convention, a CommandLine does not include the program name
 // (e.g. os.Args[0] for a top-level completion).
+//
+// The words are as the program would receive them as arguments, with
+// the quotes and backslashes the user typed removed: `my\ file' and
+// `"my file' are both the word "my file". Completions are likewise
+// unquoted, and are escaped for the shell as needed.
 type CommandLine []string
 
 // CurrentWord returns the last word of a CommandLine -- the one being
@@ -54,16 +91,24 @@ func (f FunctionCompleter) Complete(cl CommandLine) []string {
 // will check if the program is being invoked in completion mode (by
 // default, this means with a single '-do-completion' flag), and if
 // so, it will parse the command line (provided by the shell in the
-// COMP_LINE and COMP_WORD environment variables), invoke the
-// Completer, print the completions, and exit.
+// COMP_LINE and COMP_POINT environment variables, by default; see
+// SetLineEnv), invoke the Completer, print the completions, and
+// exit.
+//
+// To help debug problems with completion in the shell, if
+// $GO_CLI_COMPLETION_DEBUG names a file, CompleteIfRequested appends
+// to it a record of each completion: the COMP_LINE and COMP_POINT
+// received from the shell, the CommandLine parsed from them, and the
+// lines printed in response.
 func CompleteIfRequested(completer Completer) {
-	if len(os.Args) <= 1 || os.Args[1] != "-do-completion" {
+	if !completionRequested(os.Args) {
 		return
 	}
-	line := os.Getenv("COMP_LINE")
-	pointStr := os.Getenv("COMP_POINT")
+	lineVar, pointVar := lineEnvNames()
+	line := os.Getenv(lineVar)
+	pointStr := os.Getenv(pointVar)
 	if line == "" || pointStr == "" {
-		completionLog.Println("Completion requested, but COMP_LINE and/or COMP_POINT unset.")
+		completionLog.Printf("Completion requested, but %s and/or %s unset.", lineVar, pointVar)
 		os.Exit(1)
 	}
 
@@ -73,20 +118,355 @@ func CompleteIfRequested(completer Completer) {
 		os.Exit(1)
 	}
 
+	invocation = newInvocation(line, int(point))
+	invocation.Key = parseCompKey(os.Getenv("COMP_KEY"))
 	cl := parseLineForCompletion(line, int(point))[1:]
+	if len(cl) == 0 {
+		// The point is within the program name; there is nothing
+		// for us to complete.
+		os.Exit(0)
+	}
+
+	lines := completionLines(completer, cl)
+	if path := os.Getenv(debugLogEnv); path != "" {
+		logCompletion(path, line, int(point), cl, lines)
+	}
+	printLines(lines)
+	os.Exit(0)
+}
+
+// debugLogEnv is the environment variable naming a file to which
+// CompleteIfRequested appends a record of each completion.
+const debugLogEnv = "GO_CLI_COMPLETION_DEBUG"
+
+// logCompletion appends a record of a completion to the debug log at
+// path.
+func logCompletion(path, line string, point int, cl CommandLine, output []string) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		completionLog.Printf("Can't open debug log: %v", err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s\nCOMP_LINE: %q\nCOMP_POINT: %d\nCommandLine: %q\nOutput: %q\n\n",
+		time.Now().Format(time.RFC3339), line, point, []string(cl), output)
+}
+
+// An Invocation describes the request from the shell that started
+// the completion in progress.
+type Invocation struct {
+	// Command is the command name as typed by the user: the first
+	// word of the command line. It may differ from os.Args[0] if
+	// the program was invoked via an alias or symlink.
+	Command string
+	// Line and Point are the command line being completed and the
+	// byte offset of the cursor within it, from COMP_LINE and
+	// COMP_POINT.
+	Line  string
+	Point int
+	// Key is the key that triggered completion, from bash's
+	// COMP_KEY: TabKey for an ordinary completion, but possibly
+	// another key bound to a completion command, such as `?' for
+	// possible-completions. Completers may use it to implement
+	// behaviors such as accepting a unique match. Shells that
+	// don't report the key, and bash if it doesn't, are taken to
+	// have completed on TabKey.
+	Key rune
+}
+
+// TabKey is the Invocation.Key of an ordinary completion.
+const TabKey = '\t'
+
+// parseCompKey parses the value of COMP_KEY, the character code of
+// a key.
+func parseCompKey(s string) rune {
+	key, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || key <= 0 {
+		return TabKey
+	}
+	return rune(key)
+}
+
+var invocation *Invocation
+
+func newInvocation(line string, point int) *Invocation {
+	return &Invocation{
+		Command: parseLineForCompletion(line, len(line))[0],
+		Line:    line,
+		Point:   point,
+		Key:     TabKey,
+	}
+}
+
+// CurrentInvocation returns the Invocation for the completion in
+// progress, or nil if the program is not completing a command line
+// from the shell.
+func CurrentInvocation() *Invocation {
+	return invocation
+}
+
+// wholeLine is the CommandLine of the completion in progress. See
+// WholeCommandLine.
+var wholeLine struct {
+	sync.Mutex
+	cl CommandLine
+}
+
+// setWholeLine sets the CommandLine of the completion in progress,
+// and returns the previous one.
+func setWholeLine(cl CommandLine) (saved CommandLine) {
+	wholeLine.Lock()
+	defer wholeLine.Unlock()
+	saved, wholeLine.cl = wholeLine.cl, cl
+	return saved
+}
+
+// WholeCommandLine returns the whole CommandLine of the completion
+// in progress, of which cl, the CommandLine passed to a Completer, may
+// be only the tail, such as the positional arguments that
+// CompleterWithFlags passes on. This allows a Completer to consult
+// words that precede its own, such as flags. If no completion is in
+// progress, as when a Completer is invoked directly, it returns cl.
+func WholeCommandLine(cl CommandLine) CommandLine {
+	wholeLine.Lock()
+	defer wholeLine.Unlock()
+	if wholeLine.cl == nil {
+		return cl
+	}
+	return wholeLine.cl
+}
+
+// completionFlag is the argument with which the shell invokes the
+// program to perform completion.
+const completionFlag = "-do-completion"
+
+// completionRequested reports whether args, the program's command
+// line including its name, are those of an invocation by the shell
+// to perform completion.
+func completionRequested(args []string) bool {
+	return len(args) > 1 && args[1] == completionFlag
+}
+
+// Active reports whether the program was invoked to perform
+// completion: whether CompleteIfRequested, or CompleteAsSubcommand
+// given os.Args[1:], would complete rather than return. It may be
+// called before either, so that main can skip expensive
+// initialization, such as connecting to a database, that completion
+// doesn't need:
+//
+//	if !completion.Active() {
+//		db = openDatabase()
+//	}
+//	completion.CompleteIfRequested(completer)
+func Active() bool {
+	return completionRequested(os.Args) ||
+		(len(os.Args) > 1 && os.Args[1] == completionSubcommand)
+}
+
+// InvokedAs returns the command name the user typed to invoke the
+// program, for the completion in progress, or os.Args[0] otherwise.
+// Multi-call programs, which behave differently depending on the
+// name they are invoked by, should use InvokedAs rather than
+// os.Args[0] to decide how to complete, since the shell invokes the
+// program for completion by its registered path.
+func InvokedAs() string {
+	if invocation != nil {
+		return invocation.Command
+	}
+	return os.Args[0]
+}
+
+var completionSubcommand = "__complete"
+
+// SetCompletionSubcommand changes the name of the subcommand
+// recognized by CompleteAsSubcommand. The default is "__complete".
+func SetCompletionSubcommand(name string) {
+	completionSubcommand = name
+}
 
-	for _, word := range completer.Complete(cl) {
-		fmt.Println(word)
+// CompleteAsSubcommand is an alternate entry point to completion, for
+// shells or tools that pass the words to complete directly instead of
+// through COMP_LINE and COMP_POINT. args should be the program's
+// arguments, not including the program name (e.g. os.Args[1:]). If
+// the first argument is the completion subcommand (by default,
+// "__complete"), the remaining arguments are treated as the words of
+// the CommandLine, the last being the word to complete; the Completer
+// is invoked, its completions printed, and the program exits.
+// Otherwise, CompleteAsSubcommand returns without doing anything.
+// Since the words don't come from bash's readline, the completions
+// are printed as they are, without the escaping and adjustment for
+// COMP_WORDBREAKS that CompleteIfRequested applies for bash.
+//
+// CompleteAsSubcommand may be called alongside CompleteIfRequested.
+func CompleteAsSubcommand(args []string, completer Completer) {
+	if len(args) == 0 || args[0] != completionSubcommand {
+		return
 	}
+	printLines(RunSubcommandCompletion(completer, args))
 	os.Exit(0)
 }
 
+// RunSubcommandCompletion performs a completion as
+// CompleteAsSubcommand would for args, and returns the lines it would
+// print, instead of printing them and exiting, as RunCompletion does
+// for CompleteIfRequested. If args don't start with the completion
+// subcommand, it returns nil.
+func RunSubcommandCompletion(completer Completer, args []string) []string {
+	if len(args) == 0 || args[0] != completionSubcommand {
+		return nil
+	}
+	cl := CommandLine(args[1:])
+	if len(cl) == 0 {
+		cl = CommandLine{""}
+	}
+	// The words come from the caller, not from bash's readline, so
+	// the completions are neither adjusted for COMP_WORDBREAKS nor
+	// escaped.
+	completions := complete(completer, cl)
+	scripted := os.Getenv(shellEnv) != ""
+	return formatCompletions(completions, currentDirectives(), activeShell(), scripted, verbatim)
+}
+
+var completionTimeout time.Duration
+
+// SetCompletionTimeout bounds how long CompleteIfRequested and
+// CompleteAsSubcommand will wait for a Completer. Completion runs
+// synchronously in the user's shell, so a slow Completer makes the
+// terminal appear frozen; if the Completer has not returned within
+// d, it is abandoned, no completions are printed, and the program
+// exits. A d of zero (the default) means no timeout.
+func SetCompletionTimeout(d time.Duration) {
+	completionTimeout = d
+}
+
+// runCompleter invokes a Completer, subject to the completion
+// timeout. If the Completer times out, its directives are
+// abandoned, so that the Completer, which is left running, can't
+// affect the output by signaling late.
+func runCompleter(completer Completer, cl CommandLine) []string {
+	if completionTimeout <= 0 {
+		return completer.Complete(cl)
+	}
+
+	done := make(chan []string, 1)
+	go func() {
+		done <- completer.Complete(cl)
+	}()
+	select {
+	case completions := <-done:
+		return completions
+	case <-time.After(completionTimeout):
+		abandonDirectives()
+		completionLog.Printf("Completion timed out after %v.", completionTimeout)
+		return nil
+	}
+}
+
+var debug bool
+
+// SetDebug enables or disables debugging checks on completions,
+// intended for use while developing a Completer. With debugging
+// enabled, completions that don't start with the word being
+// completed, which shells discard or mishandle, are logged to
+// standard error.
+func SetDebug(enabled bool) {
+	debug = enabled
+}
+
+// mismatchedCompletions returns the completions that don't start
+// with the word being completed.
+func mismatchedCompletions(cl CommandLine, completions []string) (bad []string) {
+	for _, c := range completions {
+		if !strings.HasPrefix(c, cl.CurrentWord()) {
+			bad = append(bad, c)
+		}
+	}
+	return bad
+}
+
+func printLines(lines []string) {
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
+
+// complete runs a completion, with the checks enabled by SetDebug,
+// and returns the completions.
+func complete(completer Completer, cl CommandLine) []string {
+	resetDirectives()
+	// A Completer abandoned by a timeout doesn't see the line once
+	// it has been restored, but only the CommandLine it was given.
+	defer setWholeLine(setWholeLine(cl))
+	completions := runCompleter(completer, cl)
+	if debug {
+		for _, c := range mismatchedCompletions(cl, completions) {
+			completionLog.Printf("Completion %q does not match the current word %q.", c, cl.CurrentWord())
+		}
+	}
+	return completions
+}
+
+// completionLines runs a completion of a line from the shell, split
+// into words as typed, and returns the lines to print for it.
+func completionLines(completer Completer, words CommandLine) []string {
+	cl := make(CommandLine, len(words))
+	for i, word := range words {
+		cl[i], _, _ = unquoteWord(word)
+	}
+	completions := complete(completer, cl)
+
+	word := words.CurrentWord()
+	shell := activeShell()
+	if shell == Bash {
+		completions = bashCompletions(word, completions, wordbreaks())
+	}
+	_, quote, _ := unquoteWord(word)
+	scripted := os.Getenv(shellEnv) != ""
+	d := currentDirectives()
+	return formatCompletions(completions, d, shell, scripted, shell.escaper(quote, d, scripted))
+}
+
+// RunCompletion performs a completion as CompleteIfRequested would
+// for the shell-provided line and point, and returns the lines it
+// would print, instead of printing them and exiting. This allows
+// programs to test their completion without a shell, by comparing
+// the output for sample command lines against the expected
+// completions.
+func RunCompletion(completer Completer, line string, point int) []string {
+	defer func(saved *Invocation) { invocation = saved }(invocation)
+	invocation = newInvocation(line, point)
+	cl := parseLineForCompletion(line, point)[1:]
+	if len(cl) == 0 {
+		return nil
+	}
+	return completionLines(completer, cl)
+}
+
+// parseLineForCompletion splits the part of line before point into
+// words. Unquoted spaces and tabs separate words, and runs of them
+// count as one separator. The last word is the word being completed,
+// so if the line ends with a separator, the result ends with an
+// empty word.
 func parseLineForCompletion(line string, point int) CommandLine {
+	// COMP_POINT comes from the shell; don't trust it to be in range.
+	if point < 0 {
+		point = 0
+	} else if point > len(line) {
+		point = len(line)
+	}
+
+	// The line is scanned a byte at a time, rather than a rune at a
+	// time, both because point is a byte offset and so that words
+	// that aren't valid UTF-8, such as latin-1 filenames, are
+	// passed through intact. The characters the shell treats
+	// specially are all ASCII, so can't occur inside a multi-byte
+	// sequence.
 	var cl CommandLine
-	var quote rune
+	var quote byte
 	var backslash bool
-	var word []rune
-	for _, char := range line[:point] {
+	var word []byte
+	for i := 0; i < point; i++ {
+		char := line[i]
 		if backslash {
 			word = append(word, char)
 			backslash = false
@@ -128,84 +508,537 @@ func parseLineForCompletion(line string, point int) CommandLine {
 	return append(cl, string(word))
 }
 
+// unquoteWord removes the quoting from a word of a command line, as
+// the shell would in passing it to a program: a backslash escapes the
+// next character, except within single quotes, and within double
+// quotes only escapes `$', "`", `"' and `\'. If the word ends within
+// quotes, as the word being completed may, open is the quote
+// character, and start the index in word just after it.
+func unquoteWord(word string) (unquoted string, open byte, start int) {
+	var buf []byte
+	var quote byte
+	for i := 0; i < len(word); i++ {
+		char := word[i]
+		switch {
+		case quote == '\'':
+			if char == '\'' {
+				quote = 0
+			} else {
+				buf = append(buf, char)
+			}
+		case char == '\\' && i+1 < len(word) && (quote == 0 || strings.IndexByte("$`\"\\", word[i+1]) >= 0):
+			i++
+			buf = append(buf, word[i])
+		case char == '"' && quote == '"':
+			quote = 0
+		case (char == '\'' || char == '"') && quote == 0:
+			quote = char
+			start = i + 1
+		default:
+			buf = append(buf, char)
+		}
+	}
+	if quote == 0 {
+		start = 0
+	}
+	return string(buf), quote, start
+}
+
 type boolFlag interface {
 	flag.Value
 	IsBoolFlag() bool
 }
 
-func completeFlags(cl CommandLine, flags *flag.FlagSet) (completions []string, rest CommandLine) {
-	if len(cl) == 0 {
-		return nil, cl
+// FlagOptions configures the behavior of a flag-aware Completer
+// constructed by CompleterWithFlagOptions. The zero FlagOptions
+// gives the behavior of CompleterWithFlags.
+type FlagOptions struct {
+	// NegateBools causes boolean flags to also be offered in a
+	// negated "-no-<name>" form, for programs that accept that
+	// convention.
+	NegateBools bool
+
+	// HideFlagNames suppresses the completion of flag names. Flags
+	// on the command line are still recognized, so that positional
+	// arguments are passed to the inner Completer as usual and flag
+	// values are completed using Values.
+	HideFlagNames bool
+
+	// Permute enables GNU-style argument permutation, in which
+	// flags may follow positional arguments, as in
+	// `prog file.txt --verbose'. By default, as with the flag
+	// package, the first positional argument ends the flags. With
+	// Permute, flags are recognized anywhere before a `--', and
+	// the inner Completer sees only the positional arguments.
+	Permute bool
+
+	// IgnoreCase makes the completion of flag names
+	// case-insensitive, for FlagSets with mixed-case names that
+	// users type in lower case: `-maxc' completes to `-MaxCount'.
+	// Flags are offered with the case in which they were defined,
+	// since that is what the flag package accepts; the completion
+	// scripts let the candidate replace the word as typed, even
+	// though it differs in case.
+	IgnoreCase bool
+
+	// StopAtTerminal suppresses all completion once a terminal
+	// flag, such as `--help', appears on the command line before
+	// the word being completed: the program will print something
+	// and exit rather than run a command, so nothing further is
+	// worth completing. The terminal flags are those named in
+	// TerminalFlags, or DefaultTerminalFlags if it is nil; they
+	// need not be defined in the FlagSet.
+	StopAtTerminal bool
+	TerminalFlags  []string
+
+	// Arity maps the names of flags that take more than one value,
+	// as in `--point X Y', to the number of values they take. Such
+	// a flag consumes that many of the words following it, or one
+	// fewer if the first value is given inline, as in
+	// `--point=X Y'. Other flags take a single value, and boolean
+	// flags none. The flag package only ever gives a flag a single
+	// value, so a program using Arity must collect the remaining
+	// values itself. The Completer in Values for a flag listed
+	// here sees only the flag's values, up to and including the
+	// one being completed, so that the position of that value is
+	// len(cl)-1, as for PositionalHintCompleter.
+	Arity map[string]int
+
+	// Values maps flag names to Completers for the flags'
+	// values. The Completer sees the CommandLine up to and
+	// including the value being completed. A value may also be
+	// given in the same word as its flag, as in `--level=de'; in
+	// that case, the Completer sees just the value as the word
+	// being completed, and the flag is prepended to the
+	// completions it returns. Values of flags without a
+	// Completer are completed using the flag's value if it is
+	// Completable, and with NoCompletion otherwise.
+	Values map[string]Completer
+}
+
+// DefaultTerminalFlags are the terminal flags used if
+// FlagOptions.StopAtTerminal is set without TerminalFlags.
+var DefaultTerminalFlags = []string{"help", "h", "version"}
+
+// negatedPrefix is the prefix used for negated boolean flags when
+// FlagOptions.NegateBools is set.
+const negatedPrefix = "no-"
+
+func isBoolFlag(f *flag.Flag) bool {
+	bf, ok := f.Value.(boolFlag)
+	return ok && bf.IsBoolFlag()
+}
+
+// lookupFlag looks up a flag by name as it appears on the command
+// line, accounting for negated boolean flags if enabled.
+func lookupFlag(flags *flag.FlagSet, name string, opts *FlagOptions) *flag.Flag {
+	if f := flags.Lookup(name); f != nil {
+		return f
+	}
+	if opts.NegateBools && strings.HasPrefix(name, negatedPrefix) {
+		if f := flags.Lookup(name[len(negatedPrefix):]); f != nil && isBoolFlag(f) {
+			return f
+		}
+	}
+	return nil
+}
+
+// flagNames returns the names of all flags that may be offered as
+// completions, in lexical order of the underlying flags.
+func flagNames(flags *flag.FlagSet, opts *FlagOptions) []string {
+	var names []string
+	flags.VisitAll(func(f *flag.Flag) {
+		names = append(names, f.Name)
+	})
+	if opts.NegateBools {
+		flags.VisitAll(func(f *flag.Flag) {
+			if isBoolFlag(f) {
+				names = append(names, negatedPrefix+f.Name)
+			}
+		})
+	}
+	return names
+}
+
+// A WordKind classifies the word being completed in a CommandLine
+// with respect to a set of flags.
+type WordKind int
+
+const (
+	// Positional is a non-flag argument.
+	Positional WordKind = iota
+	// FlagName is a word starting with `-', naming a flag.
+	FlagName
+	// FlagValue is the value of a flag given as the previous word.
+	FlagValue
+	// AfterDoubleDash is a non-flag argument following a `--',
+	// after which no words are taken to be flags.
+	AfterDoubleDash
+)
+
+// A Context describes the word being completed in a CommandLine.
+type Context struct {
+	Kind WordKind
+	// Flag is the name of the flag whose value is being
+	// completed, if Kind is FlagValue.
+	Flag string
+	// Inline is true if the value being completed is part of
+	// the same word as the flag, as in `--level=de'.
+	Inline bool
+	// Index is the position of the value being completed among
+	// the values of a flag taking several (see FlagOptions.Arity),
+	// starting at 0.
+	Index int
+}
+
+// Classify determines whether the word being completed in a
+// CommandLine is a flag name, a flag value, or a positional argument,
+// by parsing the flags preceding it in the same way as
+// CompleterWithFlags. It allows completers to implement their own
+// dispatch without reimplementing flag parsing. With a nil FlagSet,
+// every word is positional.
+func Classify(cl CommandLine, flags *flag.FlagSet) Context {
+	ctx, _, _ := scanFlags(cl, flags, &FlagOptions{})
+	return ctx
+}
+
+// ValueOfFlag returns the value given on a CommandLine for the named
+// flag, before the word being completed. If the flag is given more
+// than once, the last value is returned. Boolean flags given without
+// a value have the value "true". This is useful for completers whose
+// behavior depends on other flags, such as a path completer rooted
+// at a directory given by a flag.
+func ValueOfFlag(cl CommandLine, flags *flag.FlagSet, name string) (value string, ok bool) {
+	args, _, _ := ParseFlags(cl, flags)
+	for _, a := range args {
+		if a.Name == name {
+			value, ok = a.Value, true
+		}
 	}
+	return value, ok
+}
+
+// A FlagArg is a flag given on a CommandLine, and its value.
+type FlagArg struct {
+	// Name is the name of the flag, as defined in the FlagSet.
+	Name string
+	// Value is the flag's value. Boolean flags given without a
+	// value have the value "true".
+	Value string
+}
+
+// ParseFlags parses the flags preceding the word being completed in
+// a CommandLine, in the same way as CompleterWithFlags. consumed
+// lists the flags found, in order, with their values. rest is the
+// remainder of the CommandLine starting at the first positional
+// argument (after any `--'), or nil if the word being completed is a
+// flag name or value. ctx classifies the word being completed, as
+// Classify does.
+func ParseFlags(cl CommandLine, flags *flag.FlagSet) (consumed []FlagArg, rest CommandLine, ctx Context) {
+	ctx, rest, consumed = scanFlags(cl, flags, &FlagOptions{})
+	return consumed, rest, ctx
+}
+
+// scanFlags scans the flags at the start of a CommandLine and
+// classifies the word being completed. rest is the remainder of the
+// CommandLine starting at the first positional argument (following
+// any `--'), or nil if the word being completed is a flag name or
+// value. args lists the flags and values that were scanned, before
+// the word being completed. If opts.Permute is set, flags are
+// scanned past positional arguments, and rest holds just the
+// positional arguments.
+func scanFlags(cl CommandLine, flags *flag.FlagSet, opts *FlagOptions) (ctx Context, rest CommandLine, args []FlagArg) {
+	if len(cl) == 0 || flags == nil {
+		return Context{Kind: Positional}, cl, nil
+	}
+	// inFlag is the flag whose values are being scanned, pending
+	// the number of values it has yet to consume, and index the
+	// number it has consumed.
 	var inFlag string
+	var pending, index int
+	arity := func(name string) int {
+		if n, ok := opts.Arity[name]; ok {
+			return n
+		}
+		return 1
+	}
+	// positionals holds the positional arguments preceding flags,
+	// if opts.Permute is set.
+	var positionals CommandLine
+	withPositionals := func(rest CommandLine) CommandLine {
+		if positionals == nil {
+			return rest
+		}
+		return append(positionals, rest...)
+	}
 	for len(cl) > 1 {
 		w := cl[0]
-		if inFlag != "" {
-			inFlag = ""
+		if pending > 0 {
+			args = append(args, FlagArg{inFlag, w})
+			pending--
+			index++
 		} else if len(w) > 1 && w[0] == '-' && w != "--" {
-			if !strings.Contains(w, "=") {
+			if name, value, ok := splitInlineValue(w); ok {
+				args = append(args, FlagArg{name, value})
+				inFlag, pending, index = name, arity(name)-1, 1
+			} else {
 				var i int
 				for i = 0; i < len(w) && w[i] == '-'; i++ {
 				}
-				inFlag = w[i:]
-			}
-			if flag := flags.Lookup(inFlag); flag != nil {
-				if bf, ok := flag.Value.(boolFlag); ok && bf.IsBoolFlag() {
-					inFlag = ""
+				inFlag, pending, index = w[i:], arity(w[i:]), 0
+				if flag := lookupFlag(flags, inFlag, opts); flag != nil && isBoolFlag(flag) {
+					value := "true"
+					if flag.Name != inFlag {
+						value = "false"
+					}
+					args = append(args, FlagArg{flag.Name, value})
+					pending = 0
 				}
 			}
 		} else {
 			if w == "--" {
-				cl = cl[1:]
+				return Context{Kind: AfterDoubleDash}, withPositionals(cl[1:]), args
+			}
+			if !opts.Permute {
+				return Context{Kind: Positional}, cl, args
 			}
-			return nil, cl
+			positionals = append(positionals, w)
 		}
 		cl = cl[1:]
 	}
 
-	if inFlag != "" {
-		// Complete a flag value. No-op for now.
-		return []string{}, nil
-	} else if len(cl[0]) > 0 && cl[0][0] == '-' {
-		// complete a flag name
-		prefix := strings.TrimLeft(cl[0], "-")
-		flags.VisitAll(func(f *flag.Flag) {
-			if strings.HasPrefix(f.Name, prefix) {
-				completions = append(completions, "-"+f.Name)
+	w := cl[0]
+	if pending > 0 {
+		return Context{Kind: FlagValue, Flag: inFlag, Index: index}, nil, args
+	} else if len(w) > 0 && w[0] == '-' {
+		if name, _, ok := splitInlineValue(w); ok {
+			return Context{Kind: FlagValue, Flag: name, Inline: true}, nil, args
+		}
+		return Context{Kind: FlagName}, nil, args
+	}
+	return Context{Kind: Positional}, withPositionals(cl), args
+}
+
+// splitInlineValue splits a word of the form `--flag=value' into the
+// flag's name and value.
+func splitInlineValue(w string) (name, value string, ok bool) {
+	i := strings.Index(w, "=")
+	if i < 0 {
+		return "", "", false
+	}
+	name = strings.TrimLeft(w[:i], "-")
+	if name == "" {
+		return "", "", false
+	}
+	return name, w[i+1:], true
+}
+
+func completeFlags(cl CommandLine, flags *flag.FlagSet, opts *FlagOptions) (completions []string, rest CommandLine) {
+	if len(cl) == 0 || flags == nil {
+		return nil, cl
+	}
+	ctx, rest, args := scanFlags(cl, flags, opts)
+	switch ctx.Kind {
+	case FlagValue:
+		if _, ok := opts.Arity[ctx.Flag]; ok {
+			// Complete the value among the flag's values.
+			values := make(CommandLine, 0, ctx.Index+1)
+			for _, a := range args[len(args)-ctx.Index:] {
+				values = append(values, a.Value)
 			}
-		})
+			cl = append(values, cl.CurrentWord())
+		}
+		return completeFlagValue(cl, flags, ctx, opts), nil
+	case FlagName:
+		if opts.HideFlagNames {
+			return nil, nil
+		}
+		prefix := strings.TrimLeft(cl.CurrentWord(), "-")
+		for _, name := range flagNames(flags, opts) {
+			if hasFlagPrefix(name, prefix, opts) {
+				completions = append(completions, "-"+name)
+			}
+		}
 		return completions, nil
+	case Positional:
+		// If no positional arguments precede the word being
+		// completed, and it's empty, it could be a flag.
+		if len(rest) == 1 && rest[0] == "" && !opts.HideFlagNames {
+			for _, name := range flagNames(flags, opts) {
+				completions = append(completions, "-"+name)
+			}
+		}
 	}
+	return completions, rest
+}
 
-	if cl[0] == "" {
-		flags.VisitAll(func(f *flag.Flag) {
-			completions = append(completions, "-"+f.Name)
-		})
+// hasFlagPrefix reports whether the flag name starts with prefix,
+// ignoring case if opts.IgnoreCase is set.
+func hasFlagPrefix(name, prefix string, opts *FlagOptions) bool {
+	if opts.IgnoreCase {
+		return len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix)
+	}
+	return strings.HasPrefix(name, prefix)
+}
+
+// sawTerminalFlag reports whether a terminal flag (see
+// FlagOptions.StopAtTerminal) precedes the word being completed.
+func sawTerminalFlag(cl CommandLine, flags *flag.FlagSet, opts *FlagOptions) bool {
+	terminal := opts.TerminalFlags
+	if terminal == nil {
+		terminal = DefaultTerminalFlags
+	}
+	isTerminalFlag := func(name string) bool {
+		for _, t := range terminal {
+			if name == t {
+				return true
+			}
+		}
+		return false
+	}
+
+	ctx, _, args := scanFlags(cl, flags, opts)
+	for _, arg := range args {
+		if isTerminalFlag(arg.Name) {
+			return true
+		}
+	}
+	// A terminal flag the FlagSet doesn't define as boolean, such
+	// as the flag package's implicit -h, is taken to expect a
+	// value; the word being completed is not really one.
+	return ctx.Kind == FlagValue && !ctx.Inline && isTerminalFlag(ctx.Flag)
+}
+
+// completeFlagValue completes the value of a flag, as classified by
+// scanFlags.
+func completeFlagValue(cl CommandLine, flags *flag.FlagSet, ctx Context, opts *FlagOptions) []string {
+	completer := opts.Values[ctx.Flag]
+	if completer == nil {
+		completer = valueCompleter(flags.Lookup(ctx.Flag))
+	}
+	if !ctx.Inline {
+		return completer.Complete(cl)
+	}
+
+	word := cl.CurrentWord()
+	_, value, _ := splitInlineValue(word)
+	prefix := word[:len(word)-len(value)]
+	valueLine := append(append(CommandLine(nil), cl[:len(cl)-1]...), value)
+	values := completer.Complete(valueLine)
+	if values == nil {
+		return nil
+	}
+	completions := make([]string, 0, len(values))
+	for _, v := range values {
+		completions = append(completions, prefix+v)
+	}
+	return completions
+}
+
+// A ValueFunc is a Completer for flag values, given the whole
+// CommandLine and the partial value being completed. Besides the
+// value, it can look at the rest of the line, such as the value of
+// another flag (see ValueOfFlag): completing `--branch' could
+// consult an earlier `--repo'. When the value is given in the same
+// word as its flag, as in `--branch=ma', partial is just the value,
+// and the completions are of the value alone (see FlagOptions.Values).
+type ValueFunc func(cl CommandLine, partial string) []string
+
+func (f ValueFunc) Complete(cl CommandLine) []string {
+	return f(cl, cl.CurrentWord())
+}
+
+// A Completable is a flag.Value that can complete its own values,
+// such as a flag accepting one of a fixed set of names. Flag-aware
+// Completers use it to complete the values of flags that have no
+// Completer in FlagOptions.Values.
+type Completable interface {
+	flag.Value
+	// CompleteValue returns the completions of partial, a
+	// partially-typed value of the flag.
+	CompleteValue(partial string) []string
+}
+
+// valueCompleter returns a Completer for the values of f, which may
+// be nil: one using f's CompleteValue method if it is Completable,
+// and NoCompletion otherwise.
+func valueCompleter(f *flag.Flag) Completer {
+	if f == nil {
+		return NoCompletion()
+	}
+	v, ok := f.Value.(Completable)
+	if !ok {
+		return NoCompletion()
 	}
-	return completions, cl
+	return FunctionCompleter(func(cl CommandLine) []string {
+		Signal(NoFileCompletion)
+		return v.CompleteValue(cl.CurrentWord())
+	})
 }
 
 type flagCompleter struct {
 	flags *flag.FlagSet
 	inner Completer
+	opts  FlagOptions
 }
 
 // CompleterWithFlags augments a Completer to be flag-aware given a
 // particular flag.FlagSet. If the word being completed is a
 // command-line flag, the resulting Completer will complete available
 // flags using the FlagSet; If it a flag value, it will suppress
-// completion, and if the word is empty and the command-line does not
-// yet include a non-flag value, the completer will return both all
-// flags and the results of invoking the underlying Completer.
+// completion (see NoCompletion), and if the word is empty and the
+// command-line does not yet include a non-flag value, the completer
+// will return both all flags and the results of invoking the
+// underlying Completer.
+//
+// Completion only looks flags up in the FlagSet, and never sets or
+// parses them, so a program can pass the same FlagSet it uses to
+// parse its command line, such as flag.CommandLine. A nil FlagSet is
+// treated as defining no flags: every word is passed to completer.
 func CompleterWithFlags(flags *flag.FlagSet, completer Completer) Completer {
+	return CompleterWithFlagOptions(flags, completer, FlagOptions{})
+}
+
+// CompleterWithFlagOptions is like CompleterWithFlags, but allows
+// the caller to customize flag completion using a FlagOptions.
+func CompleterWithFlagOptions(flags *flag.FlagSet, completer Completer, opts FlagOptions) Completer {
 	return &flagCompleter{
 		flags: flags,
 		inner: completer,
+		opts:  opts,
 	}
 }
 
+// CompleterWithFlagValues is like CompleterWithFlags, but also
+// completes the values of flags, using the Completers in values,
+// keyed by flag name. See FlagOptions.Values.
+func CompleterWithFlagValues(flags *flag.FlagSet, values map[string]Completer, completer Completer) Completer {
+	return CompleterWithFlagOptions(flags, completer, FlagOptions{Values: values})
+}
+
+// SameValues returns a map, for use as FlagOptions.Values or with
+// CompleterWithFlagValues, that completes the values of each of the
+// named flags using completer. For example, for flags --from and --to
+// that both take a node name:
+//
+//	values := SameValues(nodes, "from", "to")
+//
+// The flags share the one Completer, so it must be stateless, or
+// safe for concurrent use. The map may be extended with Completers
+// for other flags.
+func SameValues(completer Completer, flags ...string) map[string]Completer {
+	values := make(map[string]Completer, len(flags))
+	for _, name := range flags {
+		values[name] = completer
+	}
+	return values
+}
+
 func (c *flagCompleter) Complete(cl CommandLine) []string {
-	completions, rest := completeFlags(cl, c.flags)
+	if c.opts.StopAtTerminal && sawTerminalFlag(cl, c.flags, &c.opts) {
+		return nil
+	}
+	completions, rest := completeFlags(cl, c.flags, &c.opts)
 	if rest != nil {
 		if extra := c.inner.Complete(rest); extra != nil {
 			completions = append(completions, extra...)
@@ -227,7 +1060,17 @@ func (c setCompleter) Complete(cl CommandLine) (completions []string) {
 }
 
 // SetCompleter returns a Completer that completes from a fixed set of
-// possible words.
+// possible words. Completions are returned in the order in which
+// they appear in strs. If no words match, including if strs is
+// empty, the Completer returns nil.
 func SetCompleter(strs []string) Completer {
 	return setCompleter(strs)
 }
+
+// SortedSetCompleter is like SetCompleter, but returns completions in
+// lexical order, regardless of the order of strs.
+func SortedSetCompleter(strs []string) Completer {
+	sorted := append([]string(nil), strs...)
+	sort.Strings(sorted)
+	return setCompleter(sorted)
+}
@@ -1,9 +1,17 @@
 package completion
 
 import (
+	"bytes"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test(t *testing.T) { TestingT(t) }
@@ -27,6 +35,12 @@ func (s *CompletionSuite) TestParseLine(c *C) {
 		{`" a string  " with words`, " in it", []string{`" a string  "`, "with", "words"}},
 		{"a b ", "", []string{"a", "b", ""}},
 		{"pw ", "", []string{"pw", ""}},
+		{"a\tb\t", "", []string{"a", "b", ""}},
+		{"a\t\tb", "", []string{"a", "b"}},
+		{"a \t b \t\t", "c", []string{"a", "b", ""}},
+		{"a '\t' \"b\tc\"\t", "", []string{"a", "'\t'", "\"b\tc\"", ""}},
+		{"a\\\tb", "", []string{"a\\\tb"}},
+		{"\t", "", []string{""}},
 	}
 	for _, tc := range testCases {
 		line := tc.beforePoint + tc.afterPoint
@@ -36,6 +50,384 @@ func (s *CompletionSuite) TestParseLine(c *C) {
 	}
 }
 
+func (s *CompletionSuite) TestParseLinePointOutOfRange(c *C) {
+	c.Check([]string(parseLineForCompletion("hello wo", 100)), DeepEquals, []string{"hello", "wo"})
+	c.Check([]string(parseLineForCompletion("hello wo", -3)), DeepEquals, []string{""})
+}
+
+func (s *CompletionSuite) TestUnquoteWord(c *C) {
+	testCases := []struct {
+		word     string
+		unquoted string
+		open     byte
+		start    int
+	}{
+		{"plain", "plain", 0, 0},
+		{`my\ file`, "my file", 0, 0},
+		{`a\\b`, `a\b`, 0, 0},
+		{`'my file'`, "my file", 0, 0},
+		{`"my file"`, "my file", 0, 0},
+		{`'a\b'`, `a\b`, 0, 0},
+		{`"a\b\"c\$"`, `a\b"c$`, 0, 0},
+		{`"it's"`, "it's", 0, 0},
+		{`'say "hi"'`, `say "hi"`, 0, 0},
+		{`"my fi`, "my fi", '"', 1},
+		{`'my fi`, "my fi", '\'', 1},
+		{`pre'fix" x`, `prefix" x`, '\'', 4},
+		{`a"b"c"d`, "abcd", '"', 6},
+		{`trailing\`, `trailing\`, 0, 0},
+		{"", "", 0, 0},
+	}
+	for _, tc := range testCases {
+		unquoted, open, start := unquoteWord(tc.word)
+		comment := Commentf("word: %s", tc.word)
+		c.Check(unquoted, Equals, tc.unquoted, comment)
+		c.Check(open, Equals, tc.open, comment)
+		c.Check(start, Equals, tc.start, comment)
+	}
+}
+
+func (s *CompletionSuite) TestRunCompletionUnquotes(c *C) {
+	defer os.Unsetenv(shellEnv)
+	os.Unsetenv(shellEnv)
+	var seen CommandLine
+	completer := FunctionCompleter(func(cl CommandLine) []string {
+		seen = cl
+		return SetCompleter([]string{"deploy prod", "deploy $HOME"}).Complete(cl)
+	})
+
+	c.Check(RunCompletion(completer, `prog 'a b' deploy\ p`, 21), DeepEquals, []string{`deploy\ prod`})
+	c.Check(seen, DeepEquals, CommandLine{"a b", "deploy p"})
+	c.Check(RunCompletion(completer, `prog "deploy `, 13), DeepEquals, []string{"deploy prod", `deploy \$HOME`})
+	c.Check(seen, DeepEquals, CommandLine{"deploy "})
+	c.Check(RunCompletion(completer, `prog 'deploy `, 13), DeepEquals, []string{"deploy prod", "deploy $HOME"})
+
+	os.Setenv(shellEnv, "zsh")
+	c.Check(RunCompletion(completer, `prog deploy\ p`, 15), DeepEquals, []string{"0", "deploy prod"})
+}
+
+func (s *CompletionSuite) TestRunCompletionFilesWithSpaces(c *C) {
+	defer os.Unsetenv(shellEnv)
+	dir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(dir, "my dir"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "my dir", "file.txt"), nil, 0644), IsNil)
+	wd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	c.Assert(os.Chdir(dir), IsNil)
+	defer os.Chdir(wd)
+
+	os.Unsetenv(shellEnv)
+	c.Check(RunCompletion(FileCompleter(), `prog my\ d`, 10), DeepEquals, []string{`my\ dir/`})
+	c.Check(RunCompletion(FileCompleter(), `prog my\ dir/f`, 15), DeepEquals, []string{`my\ dir/file.txt`})
+	c.Check(RunCompletion(FileCompleter(), `prog "my dir/f`, 14), DeepEquals, []string{"my dir/file.txt"})
+
+	os.Setenv(shellEnv, "bash")
+	lines := RunCompletion(FileCompleter(), `prog my\ dir/f`, 15)
+	c.Check(lines[1:], DeepEquals, []string{"my dir/file.txt"})
+}
+
+func (s *CompletionSuite) TestParseLineNonUTF8(c *C) {
+	// "caf\xe9" is "café" in latin-1, and isn't valid UTF-8.
+	c.Check([]string(parseLineForCompletion("cat caf\xe9 ", 9)), DeepEquals, []string{"cat", "caf\xe9", ""})
+	c.Check([]string(parseLineForCompletion("cat 'caf\xe9 x' caf\xe9", 16)), DeepEquals,
+		[]string{"cat", "'caf\xe9 x'", "caf"})
+	c.Check([]string(parseLineForCompletion("cat \xff\\\xfe", 8)), DeepEquals, []string{"cat", "\xff\\\xfe"})
+
+	// A point in the middle of a multi-byte character splits it.
+	c.Check([]string(parseLineForCompletion("cat café", 8)), DeepEquals, []string{"cat", "caf\xc3"})
+}
+
+func (s *CompletionSuite) TestCompletionTimeout(c *C) {
+	defer SetCompletionTimeout(0)
+	block := make(chan struct{})
+	defer close(block)
+	slow := FunctionCompleter(func(cl CommandLine) []string {
+		<-block
+		return []string{"slow"}
+	})
+	fast := SetCompleter([]string{"fast"})
+
+	SetCompletionTimeout(10 * time.Millisecond)
+	c.Check(runCompleter(slow, CommandLine{""}), IsNil)
+	c.Check(runCompleter(fast, CommandLine{""}), DeepEquals, []string{"fast"})
+
+	SetCompletionTimeout(0)
+	c.Check(runCompleter(fast, CommandLine{""}), DeepEquals, []string{"fast"})
+}
+
+func (s *CompletionSuite) TestCompletionTimeoutLateSignal(c *C) {
+	defer SetCompletionTimeout(0)
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Bool("v", false, "")
+	block := make(chan struct{})
+	done := make(chan CommandLine)
+	slow := FunctionCompleter(func(cl CommandLine) []string {
+		<-block
+		Signal(NoSpace | NoFileCompletion | Verbatim)
+		done <- WholeCommandLine(cl)
+		return []string{"slow"}
+	})
+
+	SetCompletionTimeout(10 * time.Millisecond)
+	c.Check(complete(CompleterWithFlags(flags, slow), CommandLine{"-v", "a"}), IsNil)
+	close(block)
+	c.Check(<-done, DeepEquals, CommandLine{"a"})
+	c.Check(currentDirectives(), Equals, Directive(0))
+
+	// The next completion starts afresh.
+	SetCompletionTimeout(0)
+	c.Check(complete(FunctionCompleter(func(CommandLine) []string {
+		Signal(NoSpace)
+		return nil
+	}), CommandLine{""}), IsNil)
+	c.Check(currentDirectives(), Equals, NoSpace)
+}
+
+func (s *CompletionSuite) TestSetCompleter(c *C) {
+	words := []string{"zeta", "alpha", "beta", "alphabet"}
+	c.Check(SetCompleter(words).Complete(CommandLine{""}), DeepEquals, words)
+	c.Check(SetCompleter(words).Complete(CommandLine{"a"}), DeepEquals, []string{"alpha", "alphabet"})
+	c.Check(SetCompleter(words).Complete(CommandLine{"x"}), IsNil)
+
+	c.Check(SortedSetCompleter(words).Complete(CommandLine{""}), DeepEquals,
+		[]string{"alpha", "alphabet", "beta", "zeta"})
+	c.Check(SortedSetCompleter(words).Complete(CommandLine{"al"}), DeepEquals,
+		[]string{"alpha", "alphabet"})
+	c.Check(words, DeepEquals, []string{"zeta", "alpha", "beta", "alphabet"})
+}
+
+func (s *CompletionSuite) TestEmptySetCompleter(c *C) {
+	for _, words := range [][]string{nil, {}} {
+		for _, completer := range []Completer{SetCompleter(words), SortedSetCompleter(words)} {
+			c.Check(completer.Complete(CommandLine{""}), IsNil)
+			c.Check(completer.Complete(CommandLine{"a"}), IsNil)
+		}
+	}
+
+	// An empty set doesn't stop FirstCompleter from falling back.
+	first := FirstCompleter(SetCompleter([]string{}), SetCompleter([]string{"fallback"}))
+	c.Check(first.Complete(CommandLine{""}), DeepEquals, []string{"fallback"})
+}
+
+func (s *CompletionSuite) TestMismatchedCompletions(c *C) {
+	cl := CommandLine{"cmd", "--lev"}
+	c.Check(mismatchedCompletions(cl, []string{"--level", "level", "--verbose"}), DeepEquals,
+		[]string{"level", "--verbose"})
+	c.Check(mismatchedCompletions(cl, []string{"--level"}), IsNil)
+	c.Check(mismatchedCompletions(CommandLine{""}, []string{"a", "b"}), IsNil)
+}
+
+func (s *CompletionSuite) TestStyled(c *C) {
+	defer func(saved func(*os.File) bool) { isTerminal = saved }(isTerminal)
+	defer os.Unsetenv("NO_COLOR")
+	os.Unsetenv("NO_COLOR")
+
+	isTerminal = func(*os.File) bool { return false }
+	c.Check(styled("1", "text"), Equals, "text")
+
+	isTerminal = func(*os.File) bool { return true }
+	c.Check(styled("1", "text"), Equals, "\x1b[1mtext\x1b[0m")
+	os.Setenv("NO_COLOR", "")
+	c.Check(styled("1", "text"), Equals, "\x1b[1mtext\x1b[0m")
+	os.Setenv("NO_COLOR", "1")
+	c.Check(styled("1", "text"), Equals, "text")
+}
+
+func (s *CompletionSuite) TestDebugMismatch(c *C) {
+	defer func(saved func(*os.File) bool) { isTerminal = saved }(isTerminal)
+	defer func(saved *log.Logger) { completionLog = saved }(completionLog)
+	defer SetDebug(false)
+	os.Unsetenv("NO_COLOR")
+	var buf bytes.Buffer
+	completionLog = log.New(&buf, "", 0)
+	SetDebug(true)
+	completer := SetCompleter([]string{"--level"})
+
+	isTerminal = func(*os.File) bool { return false }
+	complete(FunctionCompleter(func(CommandLine) []string { return []string{"level", "--level"} }), CommandLine{"--"})
+	c.Check(buf.String(), Equals, "Completion \"level\" does not match the current word \"--\".\n")
+
+	// The log stays plain even on a terminal.
+	buf.Reset()
+	isTerminal = func(*os.File) bool { return true }
+	complete(FunctionCompleter(func(CommandLine) []string { return []string{"level"} }), CommandLine{"--"})
+	c.Check(buf.String(), Equals, "Completion \"level\" does not match the current word \"--\".\n")
+
+	buf.Reset()
+	complete(completer, CommandLine{"--"})
+	c.Check(buf.String(), Equals, "")
+}
+
+func (s *CompletionSuite) TestLogCompletion(c *C) {
+	path := filepath.Join(c.MkDir(), "comp.log")
+	logCompletion(path, "prog -x my", 10, CommandLine{"-x", "my"}, []string{"0", "my file"})
+	logCompletion(path, "prog ", 5, CommandLine{""}, nil)
+
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	records := strings.Split(strings.TrimSuffix(string(data), "\n\n"), "\n\n")
+	c.Assert(records, HasLen, 2)
+	lines := strings.Split(records[0], "\n")
+	c.Check(lines[1:], DeepEquals, []string{
+		`COMP_LINE: "prog -x my"`,
+		`COMP_POINT: 10`,
+		`CommandLine: ["-x" "my"]`,
+		`Output: ["0" "my file"]`,
+	})
+	c.Check(strings.HasSuffix(records[1], "Output: []"), Equals, true)
+}
+
+func (s *CompletionSuite) TestActive(c *C) {
+	defer func(args []string) { os.Args = args }(os.Args)
+	defer SetCompletionSubcommand("__complete")
+	testCases := []struct {
+		args   []string
+		active bool
+	}{
+		{[]string{"prog"}, false},
+		{[]string{"prog", "-do-completion"}, true},
+		{[]string{"prog", "-do-completion", "extra"}, true},
+		{[]string{"prog", "--do-completion"}, false},
+		{[]string{"prog", "-v", "-do-completion"}, false},
+		{[]string{"prog", "__complete", "a", ""}, true},
+		{[]string{"prog", "run", "__complete"}, false},
+	}
+	for _, tc := range testCases {
+		os.Args = tc.args
+		c.Check(Active(), Equals, tc.active, Commentf("args: %q", tc.args))
+	}
+
+	SetCompletionSubcommand("_complete")
+	os.Args = []string{"prog", "_complete"}
+	c.Check(Active(), Equals, true)
+	os.Args = []string{"prog", "__complete"}
+	c.Check(Active(), Equals, false)
+}
+
+func (s *CompletionSuite) TestInvocation(c *C) {
+	defer func() { invocation = nil }()
+	invocation = nil
+	c.Check(CurrentInvocation(), IsNil)
+	c.Check(InvokedAs(), Equals, os.Args[0])
+
+	invocation = newInvocation("  ls -l /tm", 3)
+	c.Check(CurrentInvocation(), DeepEquals, &Invocation{Command: "ls", Line: "  ls -l /tm", Point: 3, Key: TabKey})
+	c.Check(InvokedAs(), Equals, "ls")
+}
+
+func (s *CompletionSuite) TestParseCompKey(c *C) {
+	c.Check(parseCompKey("9"), Equals, TabKey)
+	c.Check(parseCompKey("63"), Equals, '?')
+	c.Check(parseCompKey(""), Equals, TabKey)
+	c.Check(parseCompKey("tab"), Equals, TabKey)
+	c.Check(parseCompKey("0"), Equals, TabKey)
+}
+
+func (s *CompletionSuite) TestRunCompletion(c *C) {
+	defer os.Unsetenv(shellEnv)
+	os.Unsetenv(shellEnv)
+	var seen *Invocation
+	completer := FunctionCompleter(func(cl CommandLine) []string {
+		seen = CurrentInvocation()
+		Signal(NoSpace)
+		return SetCompleter([]string{"my file", "my dir/"}).Complete(cl)
+	})
+
+	c.Check(RunCompletion(completer, "prog -x my", 10), DeepEquals, []string{"my\\ file", "my\\ dir/"})
+	c.Check(seen, DeepEquals, &Invocation{Command: "prog", Line: "prog -x my", Point: 10, Key: TabKey})
+	c.Check(CurrentInvocation(), IsNil)
+	c.Check(RunCompletion(completer, "prog -x my", 7), IsNil)
+	c.Check(RunCompletion(completer, "prog", 2), IsNil)
+
+	os.Setenv(shellEnv, "zsh")
+	c.Check(RunCompletion(completer, "prog ", 5), DeepEquals, []string{"2", "my file", "my dir/"})
+}
+
+func (s *CompletionSuite) TestRunCompletionSpaces(c *C) {
+	defer os.Unsetenv(shellEnv)
+	completer := SetCompleter([]string{"deploy prod", "deploy staging", "status"})
+	testCases := []struct {
+		shell string
+		line  string
+		lines []string
+	}{
+		{"", "prog de", []string{"deploy\\ prod", "deploy\\ staging"}},
+		{"bash", "prog de", []string{"0", "deploy\\ prod", "deploy\\ staging"}},
+		{"zsh", "prog de", []string{"0", "deploy prod", "deploy staging"}},
+		{"fish", "prog de", []string{"0", "deploy prod", "deploy staging"}},
+
+		// Continuing after a space the shell has inserted escaped,
+		// or that the user has typed within quotes.
+		{"", `prog deploy\ p`, []string{"deploy\\ prod"}},
+		{"bash", `prog deploy\ s`, []string{"0", "deploy\\ staging"}},
+		{"zsh", `prog deploy\ p`, []string{"0", "deploy prod"}},
+		{"", `prog "deploy p`, []string{"deploy prod"}},
+		{"bash", `prog 'deploy `, []string{"0", "deploy prod", "deploy staging"}},
+		{"fish", `prog "deploy p`, []string{"0", "deploy prod"}},
+	}
+	for _, tc := range testCases {
+		os.Setenv(shellEnv, tc.shell)
+		c.Check(RunCompletion(completer, tc.line, len(tc.line)), DeepEquals, tc.lines,
+			Commentf("shell: %q, line: %s", tc.shell, tc.line))
+	}
+
+	os.Unsetenv(shellEnv)
+	c.Check(RunCompletion(completer, "prog ", 5), DeepEquals,
+		[]string{"deploy\\ prod", "deploy\\ staging", "status"})
+	c.Check(RunCompletion(SetCompleter([]string{"a  b"}), "prog a", 6), DeepEquals, []string{"a\\ \\ b"})
+}
+
+func (s *CompletionSuite) TestRunSubcommandCompletion(c *C) {
+	defer os.Unsetenv(shellEnv)
+	defer SetCompletionSubcommand("__complete")
+	os.Unsetenv(shellEnv)
+	var seen CommandLine
+	completer := FunctionCompleter(func(cl CommandLine) []string {
+		seen = cl
+		return SetCompleter([]string{"build", "bundle", "clean"}).Complete(cl)
+	})
+
+	c.Check(RunSubcommandCompletion(completer, []string{"__complete", "run", "b"}), DeepEquals, []string{"build", "bundle"})
+	c.Check(seen, DeepEquals, CommandLine{"run", "b"})
+	c.Check(RunSubcommandCompletion(completer, []string{"__complete"}), DeepEquals, []string{"build", "bundle", "clean"})
+	c.Check(seen, DeepEquals, CommandLine{""})
+
+	seen = nil
+	c.Check(RunSubcommandCompletion(completer, nil), IsNil)
+	c.Check(RunSubcommandCompletion(completer, []string{"run", "__complete", "b"}), IsNil)
+	c.Check(seen, IsNil)
+
+	SetCompletionSubcommand("complete-words")
+	c.Check(RunSubcommandCompletion(completer, []string{"__complete", "b"}), IsNil)
+	c.Check(RunSubcommandCompletion(completer, []string{"complete-words", "c"}), DeepEquals, []string{"clean"})
+
+	os.Setenv(shellEnv, "fish")
+	c.Check(RunSubcommandCompletion(completer, []string{"complete-words", "x"}), DeepEquals, []string{"0"})
+}
+
+func (s *CompletionSuite) TestRunSubcommandCompletionVerbatim(c *C) {
+	defer os.Unsetenv(shellEnv)
+	flags := flag.NewFlagSet("prog", flag.ContinueOnError)
+	flags.String("level", "", "")
+	completer := CompleterWithFlagValues(flags, map[string]Completer{
+		"level": SetCompleter([]string{"debug", "info"}),
+	}, SetCompleter([]string{"my file", "host:port"}))
+
+	for _, shell := range []string{"", "bash"} {
+		os.Setenv(shellEnv, shell)
+		lines := RunSubcommandCompletion(completer, []string{"__complete", "--level=de"})
+		c.Check(lines[len(lines)-1:], DeepEquals, []string{"--level=debug"}, Commentf("shell: %q", shell))
+		lines = RunSubcommandCompletion(completer, []string{"__complete", "my"})
+		c.Check(lines[len(lines)-1:], DeepEquals, []string{"my file"}, Commentf("shell: %q", shell))
+		lines = RunSubcommandCompletion(completer, []string{"__complete", "host:"})
+		c.Check(lines[len(lines)-1:], DeepEquals, []string{"host:port"}, Commentf("shell: %q", shell))
+	}
+
+	// A line from bash, on the other hand, is adjusted for readline.
+	os.Unsetenv(shellEnv)
+	c.Check(RunCompletion(completer, "prog --level=de", 15), DeepEquals, []string{"debug"})
+}
+
 type FlagCompletionSuite struct {
 	flags flag.FlagSet
 }
@@ -69,7 +461,7 @@ func (s *FlagCompletionSuite) TestCompleteFlags(c *C) {
 	}
 	for _, tc := range testCases {
 		var cl CommandLine = append(CommandLine{"cmd"}, tc.commandLine...)
-		completions, rest := completeFlags(cl, &s.flags)
+		completions, rest := completeFlags(cl[1:], &s.flags, &FlagOptions{})
 		c.Check(completions, DeepEquals, tc.completions)
 		if tc.skip < 0 {
 			c.Check(rest, IsNil)
@@ -78,3 +470,472 @@ func (s *FlagCompletionSuite) TestCompleteFlags(c *C) {
 		}
 	}
 }
+
+func (s *FlagCompletionSuite) TestCompleteNegatedFlags(c *C) {
+	opts := &FlagOptions{NegateBools: true}
+	testCases := []struct {
+		commandLine []string
+		completions []string
+		skip        int
+	}{
+		{[]string{"-no-"}, []string{"-no-bool"}, -1},
+		{[]string{"-no-b"}, []string{"-no-bool"}, -1},
+		{[]string{"-no-i"}, nil, -1},
+		{[]string{"-"}, []string{"-bool", "-int", "-str", "-str1", "-no-bool"}, -1},
+		{[]string{"-no-bool", ""}, []string{"-bool", "-int", "-str", "-str1", "-no-bool"}, 1},
+	}
+	for _, tc := range testCases {
+		completions, rest := completeFlags(tc.commandLine, &s.flags, opts)
+		c.Check(completions, DeepEquals, tc.completions)
+		if tc.skip < 0 {
+			c.Check(rest, IsNil)
+		} else {
+			c.Check(rest, DeepEquals, CommandLine(tc.commandLine[tc.skip:]))
+		}
+	}
+
+	completions, _ := completeFlags(CommandLine{"-no-"}, &s.flags, &FlagOptions{})
+	c.Check(completions, IsNil)
+}
+
+func (s *FlagCompletionSuite) TestPermute(c *C) {
+	opts := &FlagOptions{
+		Permute: true,
+		Values:  map[string]Completer{"str": SetCompleter([]string{"debug", "info"})},
+	}
+	testCases := []struct {
+		commandLine []string
+		completions []string
+		rest        []string
+	}{
+		{[]string{"file.txt", "--b"}, []string{"-bool"}, nil},
+		{[]string{"file.txt", "-str", "d"}, []string{"debug"}, nil},
+		{[]string{"a", "-bool", "b", "--int=3", "c"}, nil, []string{"a", "b", "c"}},
+		{[]string{"a", "-str", "x", ""}, nil, []string{"a", ""}},
+		{[]string{"a", "--", "-b", ""}, nil, []string{"a", "-b", ""}},
+		{[]string{"-bool", ""}, []string{"-bool", "-int", "-str", "-str1"}, []string{""}},
+	}
+	for _, tc := range testCases {
+		completions, rest := completeFlags(tc.commandLine, &s.flags, opts)
+		c.Check(completions, DeepEquals, tc.completions,
+			Commentf("command line: %q", tc.commandLine))
+		if tc.rest == nil {
+			c.Check(rest, IsNil)
+		} else {
+			c.Check(rest, DeepEquals, CommandLine(tc.rest))
+		}
+	}
+
+	completions, rest := completeFlags(CommandLine{"file.txt", "--b"}, &s.flags, &FlagOptions{})
+	c.Check(completions, IsNil)
+	c.Check(rest, DeepEquals, CommandLine{"file.txt", "--b"})
+}
+
+func (s *FlagCompletionSuite) TestHideFlagNames(c *C) {
+	opts := &FlagOptions{
+		HideFlagNames: true,
+		Values:        map[string]Completer{"str": SetCompleter([]string{"debug", "info"})},
+	}
+	testCases := []struct {
+		commandLine []string
+		completions []string
+		skip        int
+	}{
+		{[]string{"-"}, nil, -1},
+		{[]string{"-bool", "--s"}, nil, -1},
+		{[]string{""}, nil, 0},
+		{[]string{"-bool", ""}, nil, 1},
+		{[]string{"-int", "7", "x"}, nil, 2},
+		{[]string{"-str", "d"}, []string{"debug"}, -1},
+		{[]string{"--str="}, []string{"--str=debug", "--str=info"}, -1},
+	}
+	for _, tc := range testCases {
+		completions, rest := completeFlags(tc.commandLine, &s.flags, opts)
+		c.Check(completions, DeepEquals, tc.completions,
+			Commentf("command line: %q", tc.commandLine))
+		if tc.skip < 0 {
+			c.Check(rest, IsNil)
+		} else {
+			c.Check(rest, DeepEquals, CommandLine(tc.commandLine[tc.skip:]))
+		}
+	}
+
+	completer := CompleterWithFlagOptions(&s.flags, SetCompleter([]string{"-x", "file"}), *opts)
+	c.Check(completer.Complete(CommandLine{"-bool", ""}), DeepEquals, []string{"-x", "file"})
+}
+
+func (s *FlagCompletionSuite) TestIgnoreCase(c *C) {
+	flags := flag.NewFlagSet("mixed", flag.ContinueOnError)
+	flags.Int("MaxCount", 0, "")
+	flags.Int("maxDepth", 0, "")
+	flags.Bool("Verbose", false, "")
+
+	testCases := []struct {
+		word        string
+		completions []string
+	}{
+		{"-maxc", []string{"-MaxCount"}},
+		{"--MAX", []string{"-MaxCount", "-maxDepth"}},
+		{"-v", []string{"-Verbose"}},
+		{"-x", nil},
+	}
+	for _, tc := range testCases {
+		completions, _ := completeFlags(CommandLine{tc.word}, flags, &FlagOptions{IgnoreCase: true})
+		c.Check(completions, DeepEquals, tc.completions, Commentf("word: %q", tc.word))
+	}
+
+	completions, _ := completeFlags(CommandLine{"-maxc"}, flags, &FlagOptions{})
+	c.Check(completions, IsNil)
+}
+
+func (s *FlagCompletionSuite) TestStopAtTerminal(c *C) {
+	flags := flag.NewFlagSet("prog", flag.ContinueOnError)
+	flags.Bool("verbose", false, "")
+	flags.Bool("version", false, "")
+	flags.String("out", "", "")
+	inner := SetCompleter([]string{"build", "test"})
+
+	completer := CompleterWithFlagOptions(flags, inner, FlagOptions{StopAtTerminal: true})
+	testCases := []struct {
+		commandLine []string
+		completions []string
+	}{
+		{[]string{"--help", ""}, nil},
+		{[]string{"-h", "b"}, nil},
+		{[]string{"-verbose", "--version", "--o"}, nil},
+		{[]string{"-verbose", "b"}, []string{"build"}},
+		{[]string{"-out", "help", "t"}, []string{"test"}},
+		{[]string{"--h"}, nil},
+		{[]string{"build", "--help", ""}, []string{"build", "test"}},
+	}
+	for _, tc := range testCases {
+		c.Check(completer.Complete(tc.commandLine), DeepEquals, tc.completions,
+			Commentf("command line: %q", tc.commandLine))
+	}
+
+	completer = CompleterWithFlagOptions(flags, inner, FlagOptions{
+		StopAtTerminal: true,
+		TerminalFlags:  []string{"verbose"},
+	})
+	c.Check(completer.Complete(CommandLine{"-verbose", ""}), IsNil)
+	c.Check(completer.Complete(CommandLine{"--version", "t"}), DeepEquals, []string{"test"})
+
+	completer = CompleterWithFlags(flags, inner)
+	c.Check(completer.Complete(CommandLine{"--version", "t"}), DeepEquals, []string{"test"})
+	c.Check(completer.Complete(CommandLine{"-verbose", "b"}), DeepEquals, []string{"build"})
+}
+
+func (s *FlagCompletionSuite) TestArity(c *C) {
+	opts := &FlagOptions{
+		Arity: map[string]int{"str": 2, "int": 3},
+		Values: map[string]Completer{
+			"str": ChainCompleter(PositionalHintCompleter("X", "Y"),
+				FunctionCompleter(func(cl CommandLine) []string {
+					return []string{fmt.Sprintf("%d:%s", len(cl)-1, strings.Join(cl, ","))}
+				})),
+		},
+	}
+	testCases := []struct {
+		commandLine []string
+		completions []string
+		rest        []string
+	}{
+		{[]string{"-str", "a"}, []string{"0:a"}, nil},
+		{[]string{"-str", "a", "b"}, []string{"1:a,b"}, nil},
+		{[]string{"-str", "a", "-bool", "--b"}, []string{"-bool"}, nil},
+		{[]string{"-str", "a", "b", "c"}, nil, []string{"c"}},
+		{[]string{"--str=a", "b"}, []string{"1:a,b"}, nil},
+		{[]string{"--str=a", "b", "-bool", "c"}, nil, []string{"c"}},
+		{[]string{"--str=a"}, []string{"--str=0:a"}, nil},
+		{[]string{"-int", "1", "2", "3", "-str1", "x", "y"}, nil, []string{"y"}},
+		{[]string{"-int", "1", "2", "-s"}, []string{}, nil},
+	}
+	for _, tc := range testCases {
+		completions, rest := completeFlags(tc.commandLine, &s.flags, opts)
+		c.Check(completions, DeepEquals, tc.completions,
+			Commentf("command line: %q", tc.commandLine))
+		if tc.rest == nil {
+			c.Check(rest, IsNil)
+		} else {
+			c.Check(rest, DeepEquals, CommandLine(tc.rest))
+		}
+	}
+
+	ctx, _, args := scanFlags(CommandLine{"--str=a", "b", "-int", "1", "2", ""}, &s.flags, opts)
+	c.Check(ctx, Equals, Context{Kind: FlagValue, Flag: "int", Index: 2})
+	c.Check(args, DeepEquals, []FlagArg{{"str", "a"}, {"str", "b"}, {"int", "1"}, {"int", "2"}})
+}
+
+func (s *FlagCompletionSuite) TestFlagSetUnchanged(c *C) {
+	flags := flag.NewFlagSet("live", flag.ContinueOnError)
+	verbose := flags.Bool("verbose", false, "")
+	level := flags.String("level", "info", "")
+	c.Assert(flags.Parse([]string{"-verbose", "arg"}), IsNil)
+
+	completer := CompleterWithFlagOptions(flags, SetCompleter([]string{"x"}), FlagOptions{
+		NegateBools: true,
+		Values:      map[string]Completer{"level": SetCompleter([]string{"debug"})},
+	})
+	for _, cl := range []CommandLine{
+		{""},
+		{"-"},
+		{"-no-verbose", "-level", "d"},
+		{"--level=d"},
+		{"-level", "debug", "--", ""},
+	} {
+		completer.Complete(cl)
+	}
+
+	c.Check(*verbose, Equals, true)
+	c.Check(*level, Equals, "info")
+	c.Check(flags.Args(), DeepEquals, []string{"arg"})
+	var set []string
+	flags.Visit(func(f *flag.Flag) { set = append(set, f.Name) })
+	c.Check(set, DeepEquals, []string{"verbose"})
+}
+
+func (s *FlagCompletionSuite) TestNilFlagSet(c *C) {
+	completer := CompleterWithFlags(nil, SetCompleter([]string{"-x", "build", "bench"}))
+	c.Check(completer.Complete(CommandLine{""}), DeepEquals, []string{"-x", "build", "bench"})
+	c.Check(completer.Complete(CommandLine{"-"}), DeepEquals, []string{"-x"})
+	c.Check(completer.Complete(CommandLine{"-v", "b"}), DeepEquals, []string{"build", "bench"})
+	c.Check(Classify(CommandLine{"-v", "-"}, nil), Equals, Context{Kind: Positional})
+	value, ok := ValueOfFlag(CommandLine{"-v", "x", ""}, nil, "v")
+	c.Check(value, Equals, "")
+	c.Check(ok, Equals, false)
+}
+
+func (s *FlagCompletionSuite) TestClassify(c *C) {
+	testCases := []struct {
+		commandLine []string
+		context     Context
+	}{
+		{[]string{""}, Context{Kind: Positional}},
+		{[]string{"-"}, Context{Kind: FlagName}},
+		{[]string{"-bool", "--s"}, Context{Kind: FlagName}},
+		{[]string{"-str", ""}, Context{Kind: FlagValue, Flag: "str"}},
+		{[]string{"-bool", "--int", "4"}, Context{Kind: FlagValue, Flag: "int"}},
+		{[]string{"-bool", ""}, Context{Kind: Positional}},
+		{[]string{"-str=x", "y"}, Context{Kind: Positional}},
+		{[]string{"-wtf", ""}, Context{Kind: FlagValue, Flag: "wtf"}},
+		{[]string{"file", "-"}, Context{Kind: Positional}},
+		{[]string{"--", "-"}, Context{Kind: AfterDoubleDash}},
+		{[]string{"-int", "3", "--", "a", ""}, Context{Kind: AfterDoubleDash}},
+		{[]string{}, Context{Kind: Positional}},
+		{[]string{"--str=he"}, Context{Kind: FlagValue, Flag: "str", Inline: true}},
+		{[]string{"-int=", ""}, Context{Kind: Positional}},
+		{[]string{"--=x"}, Context{Kind: FlagName}},
+	}
+	for _, tc := range testCases {
+		c.Check(Classify(tc.commandLine, &s.flags), Equals, tc.context,
+			Commentf("command line: %q", tc.commandLine))
+	}
+}
+
+func (s *FlagCompletionSuite) TestCompleteFlagValues(c *C) {
+	var seen []CommandLine
+	levels := FunctionCompleter(func(cl CommandLine) []string {
+		seen = append(seen, cl)
+		return SetCompleter([]string{"debug", "info", "warn"}).Complete(cl)
+	})
+	opts := &FlagOptions{Values: map[string]Completer{"str": levels}}
+
+	testCases := []struct {
+		commandLine []string
+		completions []string
+		valueLine   []string
+	}{
+		{[]string{"-str", "de"}, []string{"debug"}, []string{"-str", "de"}},
+		{[]string{"-bool", "--str", ""}, []string{"debug", "info", "warn"}, []string{"-bool", "--str", ""}},
+		{[]string{"--str=de"}, []string{"--str=debug"}, []string{"de"}},
+		{[]string{"-bool", "-str="}, []string{"-str=debug", "-str=info", "-str=warn"}, []string{"-bool", ""}},
+		{[]string{"--str=x"}, nil, []string{"x"}},
+	}
+	for _, tc := range testCases {
+		seen = nil
+		completions, rest := completeFlags(tc.commandLine, &s.flags, opts)
+		c.Check(completions, DeepEquals, tc.completions)
+		c.Check(rest, IsNil)
+		c.Check(seen, DeepEquals, []CommandLine{tc.valueLine})
+	}
+
+	resetDirectives()
+	completions, _ := completeFlags(CommandLine{"--int=4"}, &s.flags, opts)
+	c.Check(completions, DeepEquals, []string{})
+	c.Check(currentDirectives(), Equals, NoFileCompletion)
+
+	resetDirectives()
+	completions, _ = completeFlags(CommandLine{"--int", "4"}, &s.flags, opts)
+	c.Check(completions, DeepEquals, []string{})
+	c.Check(currentDirectives(), Equals, NoFileCompletion)
+
+	resetDirectives()
+	completeFlags(CommandLine{"--str", "4"}, &s.flags, opts)
+	c.Check(currentDirectives(), Equals, Directive(0))
+}
+
+func (s *FlagCompletionSuite) TestCompleteShortFlagValues(c *C) {
+	flags := flag.NewFlagSet("short", flag.ContinueOnError)
+	flags.Bool("v", false, "")
+	flags.String("o", "", "")
+	opts := &FlagOptions{Values: map[string]Completer{
+		"o": SetCompleter([]string{"out.txt", "out.json"}),
+	}}
+
+	testCases := []struct {
+		commandLine []string
+		completions []string
+	}{
+		{[]string{"-o", ""}, []string{"out.txt", "out.json"}},
+		{[]string{"-v", "-o", "out.j"}, []string{"out.json"}},
+		{[]string{"--o", "out.t"}, []string{"out.txt"}},
+		{[]string{"-o=out.t"}, []string{"-o=out.txt"}},
+	}
+	for _, tc := range testCases {
+		completions, rest := completeFlags(tc.commandLine, flags, opts)
+		c.Check(completions, DeepEquals, tc.completions,
+			Commentf("command line: %q", tc.commandLine))
+		c.Check(rest, IsNil)
+	}
+
+	completions, rest := completeFlags(CommandLine{"-o", "x", "-v", ""}, flags, opts)
+	c.Check(completions, DeepEquals, []string{"-o", "-v"})
+	c.Check(rest, DeepEquals, CommandLine{""})
+	c.Check(Classify(CommandLine{"-o", ""}, flags), Equals, Context{Kind: FlagValue, Flag: "o"})
+}
+
+// modeValue is an enum flag.Value that completes its own values.
+type modeValue string
+
+var modes = []string{"serial", "parallel", "partitioned"}
+
+func (m *modeValue) String() string { return string(*m) }
+func (m *modeValue) Set(v string) error {
+	for _, mode := range modes {
+		if v == mode {
+			*m = modeValue(v)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown mode %q", v)
+}
+func (m *modeValue) CompleteValue(partial string) []string {
+	var completions []string
+	for _, mode := range modes {
+		if strings.HasPrefix(mode, partial) {
+			completions = append(completions, mode)
+		}
+	}
+	return completions
+}
+
+func (s *FlagCompletionSuite) TestCompletableFlag(c *C) {
+	var mode modeValue
+	flags := flag.NewFlagSet("enum", flag.ContinueOnError)
+	flags.Var(&mode, "mode", "")
+	flags.String("other", "", "")
+	completer := CompleterWithFlags(flags, SetCompleter(nil))
+
+	testCases := []struct {
+		commandLine []string
+		completions []string
+	}{
+		{[]string{"--mode=par"}, []string{"--mode=parallel", "--mode=partitioned"}},
+		{[]string{"-mode="}, []string{"-mode=serial", "-mode=parallel", "-mode=partitioned"}},
+		{[]string{"--mode", "s"}, []string{"serial"}},
+		{[]string{"--mode=x"}, nil},
+	}
+	for _, tc := range testCases {
+		resetDirectives()
+		c.Check(completer.Complete(tc.commandLine), DeepEquals, tc.completions,
+			Commentf("command line: %q", tc.commandLine))
+		c.Check(currentDirectives(), Equals, NoFileCompletion)
+	}
+
+	override := CompleterWithFlagValues(flags,
+		map[string]Completer{"mode": SetCompleter([]string{"custom"})}, SetCompleter(nil))
+	c.Check(override.Complete(CommandLine{"--mode="}), DeepEquals, []string{"--mode=custom"})
+	c.Check(completer.Complete(CommandLine{"--other="}), DeepEquals, []string{})
+	c.Check(completer.Complete(CommandLine{"--bogus", ""}), DeepEquals, []string{})
+}
+
+func (s *FlagCompletionSuite) TestValueFunc(c *C) {
+	flags := flag.NewFlagSet("vcs", flag.ContinueOnError)
+	flags.String("repo", "", "")
+	flags.String("branch", "", "")
+	branches := map[string][]string{
+		"app": {"main", "release"},
+		"lib": {"master", "v1"},
+	}
+	completer := CompleterWithFlagValues(flags, map[string]Completer{
+		"branch": ValueFunc(func(cl CommandLine, partial string) []string {
+			repo, _ := ValueOfFlag(cl, flags, "repo")
+			return SetCompleter(branches[repo]).Complete(CommandLine{partial})
+		}),
+	}, SetCompleter(nil))
+
+	c.Check(completer.Complete(CommandLine{"--repo", "app", "--branch", ""}), DeepEquals, []string{"main", "release"})
+	c.Check(completer.Complete(CommandLine{"--repo=lib", "--branch", "m"}), DeepEquals, []string{"master"})
+	c.Check(completer.Complete(CommandLine{"--repo", "lib", "--branch=v"}), DeepEquals, []string{"--branch=v1"})
+	c.Check(completer.Complete(CommandLine{"--branch", ""}), IsNil)
+}
+
+func (s *FlagCompletionSuite) TestSameValues(c *C) {
+	flags := flag.NewFlagSet("route", flag.ContinueOnError)
+	flags.String("from", "", "")
+	flags.String("to", "", "")
+	flags.String("via", "", "")
+	nodes := SetCompleter([]string{"alpha", "beta", "gamma"})
+	values := SameValues(nodes, "from", "to")
+	c.Check(values, HasLen, 2)
+	values["via"] = SetCompleter([]string{"bridge"})
+	completer := CompleterWithFlagValues(flags, values, SetCompleter(nil))
+
+	c.Check(completer.Complete(CommandLine{"--from", "a"}), DeepEquals, []string{"alpha"})
+	c.Check(completer.Complete(CommandLine{"--from", "alpha", "--to=b"}), DeepEquals, []string{"--to=beta"})
+	c.Check(completer.Complete(CommandLine{"--via", ""}), DeepEquals, []string{"bridge"})
+	c.Check(SameValues(nodes), HasLen, 0)
+}
+
+func (s *FlagCompletionSuite) TestValueOfFlag(c *C) {
+	testCases := []struct {
+		commandLine []string
+		name        string
+		value       string
+		ok          bool
+	}{
+		{[]string{"-str", "a", ""}, "str", "a", true},
+		{[]string{"-str", "a", "--str=b", "-int", "3", ""}, "str", "b", true},
+		{[]string{"-str", "a", "--str=b", "-int", "3", ""}, "int", "3", true},
+		{[]string{"-bool", "file", ""}, "bool", "true", true},
+		{[]string{"-bool=false", ""}, "bool", "false", true},
+		{[]string{"-str", ""}, "str", "", false},
+		{[]string{"file", "-str", "a", ""}, "str", "", false},
+		{[]string{"-int", "3", "--", "-str", "a", ""}, "str", "", false},
+	}
+	for _, tc := range testCases {
+		value, ok := ValueOfFlag(tc.commandLine, &s.flags, tc.name)
+		c.Check(value, Equals, tc.value, Commentf("command line: %q", tc.commandLine))
+		c.Check(ok, Equals, tc.ok, Commentf("command line: %q", tc.commandLine))
+	}
+
+	_, _, args := scanFlags(CommandLine{"-no-bool", ""}, &s.flags, &FlagOptions{NegateBools: true})
+	c.Check(args, DeepEquals, []FlagArg{{"bool", "false"}})
+}
+
+func (s *FlagCompletionSuite) TestParseFlags(c *C) {
+	consumed, rest, ctx := ParseFlags(CommandLine{"-bool", "-str", "a", "--int=3", "file", "x"}, &s.flags)
+	c.Check(consumed, DeepEquals, []FlagArg{{"bool", "true"}, {"str", "a"}, {"int", "3"}})
+	c.Check(rest, DeepEquals, CommandLine{"file", "x"})
+	c.Check(ctx, Equals, Context{Kind: Positional})
+
+	consumed, rest, ctx = ParseFlags(CommandLine{"-str", "a", "--", "-x"}, &s.flags)
+	c.Check(consumed, DeepEquals, []FlagArg{{"str", "a"}})
+	c.Check(rest, DeepEquals, CommandLine{"-x"})
+	c.Check(ctx, Equals, Context{Kind: AfterDoubleDash})
+
+	consumed, rest, ctx = ParseFlags(CommandLine{"-bool", "-str", ""}, &s.flags)
+	c.Check(consumed, DeepEquals, []FlagArg{{"bool", "true"}})
+	c.Check(rest, IsNil)
+	c.Check(ctx, Equals, Context{Kind: FlagValue, Flag: "str"})
+}
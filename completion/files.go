@@ -0,0 +1,181 @@
+package completion
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// completePaths completes word as a path relative to root (or the
+// current directory, if root is empty). Directories are completed
+// with a trailing slash, so that completion can descend into them: a
+// word ending in a slash completes to the entries of the directory
+// it names, prefixed with the word, rather than to the directory
+// itself. Other files are included if match returns true for their
+// name. Hidden files are only completed if the word names them
+// explicitly.
+func completePaths(root, word string, match func(name string) bool) (completions []string) {
+	var dir, base string
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dir, base = word[:i+1], word[i+1:]
+	} else {
+		base = word
+	}
+
+	path := dir
+	if !filepath.IsAbs(dir) {
+		path = filepath.Join(root, dir)
+	}
+	if path == "" {
+		path = "."
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+
+	for _, fi := range entries {
+		name := fi.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		if isDir(filepath.Join(path, name), fi) {
+			completions = append(completions, dir+name+"/")
+		} else if match(name) {
+			completions = append(completions, dir+name)
+		}
+	}
+	return completions
+}
+
+// isDir reports whether a directory entry is a directory, following
+// symlinks.
+func isDir(path string, fi os.FileInfo) bool {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if st, err := os.Stat(path); err == nil {
+			fi = st
+		}
+	}
+	return fi.IsDir()
+}
+
+type pathCompleter func(name string) bool
+
+func (c pathCompleter) Complete(cl CommandLine) []string {
+	Signal(FileResults)
+	return completePaths("", cl.CurrentWord(), c)
+}
+
+// FileCompleter returns a Completer that completes paths to files
+// and directories.
+func FileCompleter() Completer {
+	return pathCompleter(func(string) bool { return true })
+}
+
+// DirCompleter returns a Completer that completes paths to
+// directories only.
+func DirCompleter() Completer {
+	return pathCompleter(func(string) bool { return false })
+}
+
+// GlobCompleter returns a Completer that completes paths to files
+// whose names match a glob pattern (see filepath.Match), such as
+// "*.go". Directories are always completed, so that completion can
+// descend into them.
+func GlobCompleter(pattern string) Completer {
+	return pathCompleter(func(name string) bool {
+		ok, _ := filepath.Match(pattern, name)
+		return ok
+	})
+}
+
+type rootedPathCompleter func(CommandLine) string
+
+func (c rootedPathCompleter) Complete(cl CommandLine) []string {
+	Signal(FileResults)
+	return completePaths(c(WholeCommandLine(cl)), cl.CurrentWord(), func(string) bool { return true })
+}
+
+// RootedPathCompleter returns a Completer that completes paths to
+// files and directories relative to a root directory, determined
+// for each completion by calling getRoot with the whole command line
+// (see WholeCommandLine). The completed paths are relative to the
+// root. If getRoot returns the empty string, paths are completed
+// relative to the current directory.
+//
+// RootedPathCompleter is intended for programs that interpret paths
+// relative to a directory given by a flag, such as
+//
+//	prog -root DIR path...
+//
+// in which case getRoot can use ValueOfFlag to find the root on the
+// CommandLine. Since getRoot sees the flags even though the
+// Completer passed to CompleterWithFlags is only given the
+// positional arguments, the two compose directly:
+//
+//	CompleterWithFlags(flags, RootedPathCompleter(func(cl CommandLine) string {
+//		root, _ := ValueOfFlag(cl, flags, "root")
+//		return root
+//	}))
+func RootedPathCompleter(getRoot func(CommandLine) string) Completer {
+	return rootedPathCompleter(getRoot)
+}
+
+type atFileCompleter struct {
+	inner Completer
+}
+
+func (c atFileCompleter) Complete(cl CommandLine) []string {
+	word := cl.CurrentWord()
+	if !strings.HasPrefix(word, "@") {
+		return c.inner.Complete(cl)
+	}
+	var completions []string
+	for _, path := range completePaths("", word[1:], func(string) bool { return true }) {
+		completions = append(completions, "@"+path)
+	}
+	// The completions aren't file names as far as the shell is
+	// concerned, so it won't know not to add a space after a
+	// directory.
+	if len(completions) == 1 && strings.HasSuffix(completions[0], "/") {
+		Signal(NoSpace)
+	}
+	return completions
+}
+
+// AtFileCompleter returns a Completer for programs that read
+// arguments from a file named by an argument of the form `@file'. If
+// the word being completed starts with `@', the rest of it is
+// completed as a path, keeping the `@'; otherwise, inner is invoked.
+func AtFileCompleter(inner Completer) Completer {
+	return atFileCompleter{inner}
+}
+
+type globPreservingCompleter struct {
+	inner Completer
+}
+
+func (c globPreservingCompleter) Complete(cl CommandLine) []string {
+	word := cl.CurrentWord()
+	if !strings.ContainsAny(word, "*?[") {
+		return c.inner.Complete(cl)
+	}
+	// Don't let the shell fall back to its own file completion,
+	// or escape the metacharacters as it would in a file name.
+	Signal(NoFileCompletion | Verbatim)
+	return []string{word}
+}
+
+// PreserveGlobs returns a Completer for programs that take glob
+// patterns and expand them themselves, such as `prog "*.go"'. If the
+// word being completed contains glob metacharacters (`*', `?' or
+// `['), it is offered unchanged, rather than being expanded into the
+// paths it matches, which would defeat the purpose of passing a
+// pattern; otherwise, inner, typically a FileCompleter, is invoked.
+func PreserveGlobs(inner Completer) Completer {
+	return globPreservingCompleter{inner}
+}
@@ -0,0 +1,199 @@
+package completion
+
+import (
+	"flag"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+type FileSuite struct {
+	dir string
+}
+
+var _ = Suite(&FileSuite{})
+
+func (s *FileSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	for _, d := range []string{"src", "src/pkg", "srv", ".git"} {
+		c.Assert(os.Mkdir(filepath.Join(s.dir, d), 0755), IsNil)
+	}
+	for _, f := range []string{"main.go", "main_test.go", "README", "src/a.go", "src/b.txt", ".hidden"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(s.dir, f), nil, 0644), IsNil)
+	}
+}
+
+func (s *FileSuite) TestCompletePaths(c *C) {
+	all := func(string) bool { return true }
+	testCases := []struct {
+		word        string
+		completions []string
+	}{
+		{"", []string{"README", "main.go", "main_test.go", "src/", "srv/"}},
+		{"m", []string{"main.go", "main_test.go"}},
+		{"sr", []string{"src/", "srv/"}},
+		{"src", []string{"src/"}},
+		{"src/", []string{"src/a.go", "src/b.txt", "src/pkg/"}},
+		{"src/a", []string{"src/a.go"}},
+		{".", []string{".git/", ".hidden"}},
+		{"nothing", nil},
+		{"nothing/", nil},
+	}
+	for _, tc := range testCases {
+		c.Check(completePaths(s.dir, tc.word, all), DeepEquals, tc.completions,
+			Commentf("word: %q", tc.word))
+	}
+}
+
+func (s *FileSuite) TestTrailingSlash(c *C) {
+	all := func(string) bool { return true }
+	c.Assert(os.Symlink(filepath.Join(s.dir, "src"), filepath.Join(s.dir, "link")), IsNil)
+	testCases := []struct {
+		word        string
+		completions []string
+	}{
+		// A directory named without a slash is offered with one,
+		// so that the next completion descends into it.
+		{"src", []string{"src/"}},
+		{"src/pkg", []string{"src/pkg/"}},
+		{"link", []string{"link/"}},
+		{"./src", []string{"./src/"}},
+		// With a slash, its entries are offered instead.
+		{"src/", []string{"src/a.go", "src/b.txt", "src/pkg/"}},
+		{"link/", []string{"link/a.go", "link/b.txt", "link/pkg/"}},
+		{"./src/", []string{"./src/a.go", "./src/b.txt", "./src/pkg/"}},
+		{"src/pkg/", nil},
+		{"main.go/", nil},
+	}
+	for _, tc := range testCases {
+		c.Check(completePaths(s.dir, tc.word, all), DeepEquals, tc.completions,
+			Commentf("word: %q", tc.word))
+	}
+}
+
+func (s *FileSuite) TestCompletePathsAbsolute(c *C) {
+	completions := completePaths("", s.dir+"/src/", func(string) bool { return true })
+	c.Check(completions, DeepEquals, []string{
+		s.dir + "/src/a.go", s.dir + "/src/b.txt", s.dir + "/src/pkg/"})
+}
+
+func (s *FileSuite) TestFileCompleters(c *C) {
+	wd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	c.Assert(os.Chdir(s.dir), IsNil)
+	defer os.Chdir(wd)
+
+	resetDirectives()
+	c.Check(FileCompleter().Complete(CommandLine{"src/"}), DeepEquals,
+		[]string{"src/a.go", "src/b.txt", "src/pkg/"})
+	c.Check(currentDirectives(), Equals, FileResults)
+
+	c.Check(DirCompleter().Complete(CommandLine{"src/"}), DeepEquals,
+		[]string{"src/pkg/"})
+	c.Check(GlobCompleter("*.go").Complete(CommandLine{"src/"}), DeepEquals,
+		[]string{"src/a.go", "src/pkg/"})
+	c.Check(GlobCompleter("*.go").Complete(CommandLine{""}), DeepEquals,
+		[]string{"main.go", "main_test.go", "src/", "srv/"})
+}
+
+func (s *FileSuite) TestRootedPathCompleter(c *C) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("root", "", "")
+	paths := RootedPathCompleter(func(cl CommandLine) string {
+		root, _ := ValueOfFlag(cl, flags, "root")
+		return root
+	})
+	completer := FunctionCompleter(func(cl CommandLine) []string {
+		if Classify(cl, flags).Kind == Positional {
+			return paths.Complete(cl)
+		}
+		return CompleterWithFlags(flags, paths).Complete(cl)
+	})
+
+	c.Check(completer.Complete(CommandLine{"-root", s.dir + "/src", "a"}), DeepEquals,
+		[]string{"a.go"})
+	c.Check(completer.Complete(CommandLine{"-root=" + s.dir, "src/"}), DeepEquals,
+		[]string{"src/a.go", "src/b.txt", "src/pkg/"})
+
+	wd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	c.Assert(os.Chdir(s.dir+"/src"), IsNil)
+	defer os.Chdir(wd)
+	c.Check(completer.Complete(CommandLine{"b"}), DeepEquals, []string{"b.txt"})
+	c.Check(completer.Complete(CommandLine{"-ro"}), DeepEquals, []string{"-root"})
+}
+
+func (s *FileSuite) TestRootedPathCompleterWithFlags(c *C) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("root", "", "")
+	completer := CompleterWithFlags(flags, RootedPathCompleter(func(cl CommandLine) string {
+		root, _ := ValueOfFlag(cl, flags, "root")
+		return root
+	}))
+
+	line := "prog -root " + s.dir + "/src a"
+	c.Check(RunCompletion(completer, line, len(line)), DeepEquals, []string{"a.go"})
+	line = "prog -root=" + s.dir + " src/p"
+	c.Check(RunCompletion(completer, line, len(line)), DeepEquals, []string{"src/pkg/"})
+	c.Check(WholeCommandLine(CommandLine{"a"}), DeepEquals, CommandLine{"a"})
+}
+
+func (s *FileSuite) TestAtFileCompleter(c *C) {
+	wd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	c.Assert(os.Chdir(s.dir), IsNil)
+	defer os.Chdir(wd)
+
+	completer := AtFileCompleter(SetCompleter([]string{"@literal", "build"}))
+	resetDirectives()
+	c.Check(completer.Complete(CommandLine{"@m"}), DeepEquals, []string{"@main.go", "@main_test.go"})
+	c.Check(currentDirectives(), Equals, Directive(0))
+	c.Check(completer.Complete(CommandLine{"@src/"}), DeepEquals, []string{"@src/a.go", "@src/b.txt", "@src/pkg/"})
+	c.Check(completer.Complete(CommandLine{"@x"}), IsNil)
+
+	c.Check(completer.Complete(CommandLine{"@src/p"}), DeepEquals, []string{"@src/pkg/"})
+	c.Check(currentDirectives(), Equals, NoSpace)
+
+	c.Check(completer.Complete(CommandLine{"b"}), DeepEquals, []string{"build"})
+	c.Check(completer.Complete(CommandLine{""}), DeepEquals, []string{"@literal", "build"})
+}
+
+func (s *FileSuite) TestPreserveGlobs(c *C) {
+	wd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	c.Assert(os.Chdir(s.dir), IsNil)
+	defer os.Chdir(wd)
+
+	completer := PreserveGlobs(FileCompleter())
+	resetDirectives()
+	c.Check(completer.Complete(CommandLine{"src/*.go"}), DeepEquals, []string{"src/*.go"})
+	c.Check(currentDirectives(), Equals, NoFileCompletion|Verbatim)
+	c.Check(completer.Complete(CommandLine{"ma?n.go"}), DeepEquals, []string{"ma?n.go"})
+	c.Check(completer.Complete(CommandLine{"[ms]*"}), DeepEquals, []string{"[ms]*"})
+
+	resetDirectives()
+	c.Check(completer.Complete(CommandLine{"src/p"}), DeepEquals, []string{"src/pkg/"})
+	c.Check(currentDirectives(), Equals, FileResults)
+}
+
+func (s *FileSuite) TestPreserveGlobsOutput(c *C) {
+	defer os.Unsetenv(shellEnv)
+	wd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	c.Assert(os.Chdir(s.dir), IsNil)
+	defer os.Chdir(wd)
+
+	completer := PreserveGlobs(FileCompleter())
+	os.Unsetenv(shellEnv)
+	c.Check(RunCompletion(completer, "prog *.go", 9), DeepEquals, []string{"*.go"})
+	c.Check(RunCompletion(completer, `prog "*.go`, 10), DeepEquals, []string{"*.go"})
+	c.Check(RunCompletion(completer, "prog 'src/*.go", 14), DeepEquals, []string{"src/*.go"})
+	c.Check(RunCompletion(completer, "prog src/p", 10), DeepEquals, []string{"src/pkg/"})
+
+	os.Setenv(shellEnv, "bash")
+	directives := strconv.Itoa(int(NoFileCompletion | Verbatim))
+	c.Check(RunCompletion(completer, "prog *.go", 9), DeepEquals, []string{directives, "*.go"})
+	c.Check(RunCompletion(completer, `prog "*.go`, 10), DeepEquals, []string{directives, "*.go"})
+}
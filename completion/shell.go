@@ -0,0 +1,536 @@
+package completion
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// shellEnv is the environment variable set by the generated shell
+// scripts to identify themselves when invoking a program in
+// completion mode.
+const shellEnv = "GO_CLI_COMPLETION_SHELL"
+
+// scriptLineEnv and scriptPointEnv are the environment variables by
+// which the generated zsh and fish scripts pass the command line and
+// cursor position, so as not to clash with bash's.
+const (
+	scriptLineEnv  = "GO_CLI_COMP_LINE"
+	scriptPointEnv = "GO_CLI_COMP_POINT"
+)
+
+var lineEnv, pointEnv = "COMP_LINE", "COMP_POINT"
+
+// SetLineEnv changes the names of the environment variables from
+// which CompleteIfRequested reads the command line being completed
+// and the cursor position, for shell integrations other than the
+// generated scripts. The defaults are bash's COMP_LINE and
+// COMP_POINT. The scripts generated by ZshScript and FishScript pass
+// GO_CLI_COMP_LINE and GO_CLI_COMP_POINT instead, which are always
+// recognized.
+func SetLineEnv(line, point string) {
+	lineEnv, pointEnv = line, point
+}
+
+// lineEnvNames returns the names of the environment variables
+// holding the command line and cursor position for the completion in
+// progress.
+func lineEnvNames() (line, point string) {
+	if _, ok := os.LookupEnv(scriptLineEnv); ok {
+		return scriptLineEnv, scriptPointEnv
+	}
+	return lineEnv, pointEnv
+}
+
+// A Shell identifies a shell requesting completions.
+type Shell string
+
+// The shells supported by this package.
+const (
+	Bash Shell = "bash"
+	Zsh  Shell = "zsh"
+	Fish Shell = "fish"
+)
+
+// activeShell returns the shell requesting completions, as reported
+// by the generated scripts. Plain `complete -C' registrations don't
+// report a shell, so the default is bash.
+func activeShell() Shell {
+	switch sh := Shell(os.Getenv(shellEnv)); sh {
+	case Zsh, Fish:
+		return sh
+	}
+	return Bash
+}
+
+// bashSpecial lists the characters that must be escaped for bash to
+// read a completion as a single, literal word.
+const bashSpecial = " \t\n'\"\\$`!&;|<>()*?[]{}#~"
+
+// Escape quotes a completion so that it is inserted literally into
+// the command line by the shell. Completions for bash are escaped
+// with backslashes; zsh and fish quote the completions they are
+// given themselves, so Escape returns them unchanged.
+func (s Shell) Escape(word string) string {
+	switch s {
+	case Zsh, Fish:
+		return word
+	}
+	var buf []byte
+	for i := 0; i < len(word); i++ {
+		if strings.IndexByte(bashSpecial, word[i]) >= 0 {
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, word[i])
+	}
+	return string(buf)
+}
+
+// defaultWordbreaks is bash's default value of COMP_WORDBREAKS.
+const defaultWordbreaks = " \t\n\"'@><=;|&(:"
+
+// wordbreaks returns the characters bash treats as word separators
+// for completion. The generated bash script passes COMP_WORDBREAKS
+// through; otherwise, bash's default is assumed.
+func wordbreaks() string {
+	if wb, ok := os.LookupEnv("COMP_WORDBREAKS"); ok {
+		return wb
+	}
+	return defaultWordbreaks
+}
+
+// bashCompletions adjusts the completions of word, as typed, for
+// bash, which replaces only part of the word being completed with
+// them. If the word ends within quotes, bash replaces the part after
+// the opening quote; otherwise, the part after the last of the
+// characters in breaks (see trimWordbreaks).
+func bashCompletions(word string, completions []string, breaks string) []string {
+	if _, open, start := unquoteWord(word); open != 0 {
+		prefix, _, _ := unquoteWord(word[:start-1])
+		return trimPrefix(completions, prefix)
+	}
+	return trimWordbreaks(word, completions, breaks)
+}
+
+// trimWordbreaks adjusts completions of word for bash, which takes
+// the word being completed to start after the last of the characters
+// in breaks, such as `=' or `:', and replaces only that part of the
+// word. Completions of `host:p' must then be `port' rather than
+// `host:port', or the user's line would end up with `host:host:port'.
+// Whitespace and quotes, which our own parsing already treats as
+// separators, are ignored, as are quoted or escaped characters.
+func trimWordbreaks(word string, completions []string, breaks string) []string {
+	breaks = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" \t\n\"'", r) {
+			return -1
+		}
+		return r
+	}, breaks)
+	i := -1
+	var quote byte
+	for j := 0; j < len(word); j++ {
+		switch char := word[j]; {
+		case quote == '\'':
+			if char == '\'' {
+				quote = 0
+			}
+		case char == '\\':
+			j++
+		case quote == '"':
+			if char == '"' {
+				quote = 0
+			}
+		case char == '\'' || char == '"':
+			quote = char
+		case strings.IndexByte(breaks, char) >= 0:
+			i = j
+		}
+	}
+	if i < 0 {
+		return completions
+	}
+	prefix, _, _ := unquoteWord(word[:i+1])
+	return trimPrefix(completions, prefix)
+}
+
+// trimPrefix removes prefix from the start of each of completions.
+func trimPrefix(completions []string, prefix string) []string {
+	if prefix == "" {
+		return completions
+	}
+	trimmed := make([]string, 0, len(completions))
+	for _, c := range completions {
+		trimmed = append(trimmed, strings.TrimPrefix(c, prefix))
+	}
+	return trimmed
+}
+
+// A Directive tells the shell integration how to treat the
+// completions returned by a Completer. Completers report directives
+// by calling Signal.
+type Directive int
+
+const (
+	// FileResults indicates that the completions are file paths.
+	// Under bash, this enables `compopt -o filenames', so that
+	// completions are escaped as file names and directories
+	// don't get a trailing space.
+	FileResults Directive = 1 << iota
+	// NoSpace indicates that the shell should not append a space
+	// after inserting a completion, so that the user can continue
+	// typing the same word.
+	NoSpace
+	// NoFileCompletion indicates that the shell should not fall
+	// back to completing file names if there are no completions,
+	// as bash does by default. Under bash, this disables
+	// `compopt -o default' for the completion.
+	NoFileCompletion
+	// Verbatim indicates that the completions are to be inserted
+	// as they are, rather than escaped so that the program
+	// receives them literally. It is meant for completions that
+	// the shell should interpret, such as glob patterns.
+	Verbatim
+)
+
+var directives struct {
+	sync.Mutex
+	d Directive
+	// abandoned is set when a completion times out; the
+	// abandoned Completer's signals are ignored until the next
+	// completion starts.
+	abandoned bool
+}
+
+// Signal records directives for the completion currently in
+// progress. A Completer calls Signal from Complete when the
+// completions it returns need special treatment by the shell.
+// Directives accumulate over the course of a completion.
+func Signal(d Directive) {
+	directives.Lock()
+	defer directives.Unlock()
+	if !directives.abandoned {
+		directives.d |= d
+	}
+}
+
+func resetDirectives() {
+	directives.Lock()
+	defer directives.Unlock()
+	directives.d = 0
+	directives.abandoned = false
+}
+
+// abandonDirectives clears the directives of a completion that timed
+// out, and ignores any that its Completer signals later.
+func abandonDirectives() {
+	directives.Lock()
+	defer directives.Unlock()
+	directives.d = 0
+	directives.abandoned = true
+}
+
+func currentDirectives() Directive {
+	directives.Lock()
+	defer directives.Unlock()
+	return directives.d
+}
+
+// descriptionSep separates a completion from its description.
+const descriptionSep = "\t"
+
+// Describe attaches a description to a completion, such as a
+// one-line summary of a subcommand. Shells that can show
+// descriptions (zsh and fish) list them alongside the completions;
+// under bash, they are dropped. A Completer returns the result of
+// Describe in place of the bare completion; since the completion
+// comes first, matching it against the word being completed with
+// strings.HasPrefix works as usual.
+func Describe(completion, description string) string {
+	return completion + descriptionSep + description
+}
+
+// Hint returns a placeholder describing the argument expected, such
+// as "<file>", for a Completer to return alongside or instead of its
+// completions. zsh shows hints in its listing, but doesn't insert
+// them; the other shells can't show text that isn't a completion,
+// so hints are dropped for them.
+func Hint(hint string) string {
+	return Describe("", hint)
+}
+
+// splitDescription splits a completion from its description, if it
+// has one.
+func splitDescription(c string) (completion, description string) {
+	if i := strings.Index(c, descriptionSep); i >= 0 {
+		return c[:i], c[i+len(descriptionSep):]
+	}
+	return c, ""
+}
+
+var alignDescriptions bool
+
+// SetAlignDescriptions enables or disables aligning descriptions
+// (see Describe) in a column, by padding each completion to the
+// width of the longest one, up to half the terminal width given by
+// $COLUMNS. This affects only the listing shown by zsh; fish aligns
+// descriptions itself, and bash doesn't show them.
+func SetAlignDescriptions(enabled bool) {
+	alignDescriptions = enabled
+}
+
+// descriptionWidth returns the width to which to pad completions
+// before their descriptions in a listing.
+func descriptionWidth(completions []string) int {
+	width := 0
+	for _, c := range completions {
+		word, desc := splitDescription(c)
+		if desc != "" && utf8.RuneCountInString(word) > width {
+			width = utf8.RuneCountInString(word)
+		}
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 && width > columns/2 {
+		width = columns / 2
+	}
+	return width
+}
+
+// completionOutput formats completions as the lines to print for
+// the shell. scripted indicates whether the program was invoked by
+// one of the generated scripts, which expect the directives on the
+// first line.
+//
+// Completions with descriptions are printed as the completion and,
+// after a tab, the description for fish, or the text to list in
+// place of the completion for zsh. Descriptions are dropped for
+// bash. Hints (see Hint) are printed as a tab and the hint for zsh,
+// and dropped for the other shells.
+func completionOutput(completions []string, d Directive, shell Shell, scripted bool) []string {
+	return formatCompletions(completions, d, shell, scripted, shell.escaper(0, d, scripted))
+}
+
+// escaper returns the function with which to escape completions for
+// the shell, given the directives, and the quote character within
+// which the word being completed ends, or 0 if it doesn't.
+func (s Shell) escaper(quote byte, d Directive, scripted bool) func(string) string {
+	if s != Bash {
+		return s.Escape
+	}
+	switch {
+	case d&Verbatim != 0:
+		return verbatim
+	case scripted && d&FileResults != 0:
+		// Under `compopt -o filenames', bash escapes the
+		// completions itself.
+		return verbatim
+	case quote == '"':
+		return escapeDoubleQuoted
+	case quote == '\'':
+		// Nothing can be escaped within single quotes.
+		return verbatim
+	}
+	return s.Escape
+}
+
+// verbatim returns word unchanged, for completions that must not be
+// escaped.
+func verbatim(word string) string {
+	return word
+}
+
+// escapeDoubleQuoted escapes the characters that are special within
+// double quotes.
+func escapeDoubleQuoted(word string) string {
+	var buf []byte
+	for i := 0; i < len(word); i++ {
+		if strings.IndexByte("$`\"\\", word[i]) >= 0 {
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, word[i])
+	}
+	return string(buf)
+}
+
+// formatCompletions is like completionOutput, but escapes the
+// completions with escape, rather than as the shell requires.
+func formatCompletions(completions []string, d Directive, shell Shell, scripted bool, escape func(string) string) []string {
+	var lines []string
+	if scripted {
+		lines = append(lines, strconv.Itoa(int(d)))
+	}
+	width := 0
+	if alignDescriptions && shell == Zsh {
+		width = descriptionWidth(completions)
+	}
+	for _, c := range completions {
+		word, desc := splitDescription(c)
+		if word == "" && desc != "" {
+			if scripted && shell == Zsh {
+				lines = append(lines, descriptionSep+desc)
+			}
+			continue
+		}
+		line := escape(word)
+		if desc != "" && scripted {
+			switch shell {
+			case Zsh:
+				pad := width - utf8.RuneCountInString(word)
+				if pad < 0 {
+					pad = 0
+				}
+				line += descriptionSep + word + strings.Repeat(" ", pad) + " -- " + desc
+			case Fish:
+				line += descriptionSep + desc
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+const bashTemplate = `%[1]s() {
+	local line directives=
+	COMPREPLY=()
+	while IFS= read -r line; do
+		if [ -z "$directives" ]; then
+			directives=$line
+		elif [ -n "$line" ]; then
+			COMPREPLY+=("$line")
+		fi
+	done < <(` + shellEnv + `=bash COMP_LINE="$COMP_LINE" COMP_POINT="$COMP_POINT" COMP_WORDBREAKS="$COMP_WORDBREAKS" COMP_KEY="$COMP_KEY" %[2]s -do-completion)
+	if (( directives & %[4]d )); then
+		compopt -o filenames
+	fi
+	if (( directives & %[5]d )); then
+		compopt -o nospace
+	fi
+	if (( directives & %[6]d )); then
+		compopt +o default
+	fi
+}
+complete -o default -F %[1]s %[3]s
+`
+
+// BashScript returns a bash script that registers completion for the
+// command name, by invoking program in completion mode (see
+// CompleteIfRequested). program is typically the path to the
+// program's executable; it is quoted in the script, and so may
+// contain spaces or other special characters.
+//
+// Programs can offer the script to users (for instance, when invoked
+// with a -bash-completion flag), who then load it with
+//
+//	eval "$(prog -bash-completion)"
+//
+// in their .bashrc.
+func BashScript(name, program string) string {
+	return fmt.Sprintf(bashTemplate,
+		"_go_cli_complete_"+shellIdentifier(name),
+		shellQuote(program), shellQuote(name),
+		FileResults, NoSpace, NoFileCompletion)
+}
+
+// BashScriptForExecutable is like BashScript, but invokes the
+// running program by its absolute path, as reported by
+// os.Executable, so that the script works regardless of $PATH or
+// the current directory.
+func BashScriptForExecutable(name string) (string, error) {
+	program, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if program, err = filepath.Abs(program); err != nil {
+		return "", err
+	}
+	return BashScript(name, program), nil
+}
+
+const zshTemplate = `%[1]s() {
+	local line hint directives= point described=
+	local -a candidates displays hints opts
+	() { setopt localoptions nomultibyte; point=${#LBUFFER} }
+	while IFS= read -r line; do
+		if [[ -z $directives ]]; then
+			directives=$line
+		elif [[ $line == $'\t'* ]]; then
+			hints+=("${line#$'\t'}")
+		elif [[ $line == *$'\t'* ]]; then
+			candidates+=("${line%%%%$'\t'*}")
+			displays+=("${line#*$'\t'}")
+			described=1
+		elif [[ -n $line ]]; then
+			candidates+=("$line")
+			displays+=("$line")
+		fi
+	done < <(` + shellEnv + `=zsh ` + scriptLineEnv + `="$LBUFFER" ` + scriptPointEnv + `="$point" COLUMNS="$COLUMNS" %[2]s -do-completion)
+	if (( directives & %[4]d )); then
+		opts+=(-f)
+	fi
+	if (( directives & %[5]d )); then
+		opts+=(-S '')
+	fi
+	if (( directives & %[6]d )); then
+		opts+=(-Q)
+	fi
+	if [[ -n $described ]]; then
+		opts+=(-l)
+	fi
+	for hint in "${hints[@]}"; do
+		compadd -x "$hint"
+	done
+	compadd -U -d displays "${opts[@]}" -- "${candidates[@]}"
+}
+compdef %[1]s %[3]s
+`
+
+// ZshScript is like BashScript, but returns a script for zsh. The
+// script requires the zsh completion system to have been initialized
+// with compinit.
+func ZshScript(name, program string) string {
+	return fmt.Sprintf(zshTemplate,
+		"_go_cli_complete_"+shellIdentifier(name),
+		shellQuote(program), shellQuote(name),
+		FileResults, NoSpace, Verbatim)
+}
+
+const fishTemplate = `function %[1]s
+	set -l line (commandline -cp)
+	set -l out (env ` + shellEnv + `=fish ` + scriptLineEnv + `="$line" ` + scriptPointEnv + `=(printf '%%s' "$line" | wc -c | string trim) %[2]s -do-completion)
+	set -e out[1]
+	string join \n -- $out
+end
+complete -c %[3]s -f -a '(%[1]s)'
+`
+
+// FishScript is like BashScript, but returns a script for fish.
+func FishScript(name, program string) string {
+	return fmt.Sprintf(fishTemplate,
+		"__go_cli_complete_"+shellIdentifier(name),
+		fishQuote(program), fishQuote(name))
+}
+
+// fishQuote quotes a string for use as a single word in fish.
+func fishQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return "'" + strings.Replace(s, "'", `\'`, -1) + "'"
+}
+
+// shellQuote quotes a string for use as a single word in a POSIX
+// shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// shellIdentifier converts a string into something usable as part
+// of a shell function name.
+func shellIdentifier(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
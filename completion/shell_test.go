@@ -0,0 +1,176 @@
+package completion
+
+import (
+	. "launchpad.net/gocheck"
+	"os"
+	"strings"
+)
+
+type ShellSuite struct{}
+
+var _ = Suite(&ShellSuite{})
+
+func (s *ShellSuite) TestShellQuote(c *C) {
+	c.Check(shellQuote("prog"), Equals, "'prog'")
+	c.Check(shellQuote("/opt/my tools/prog"), Equals, "'/opt/my tools/prog'")
+	c.Check(shellQuote("it's"), Equals, `'it'\''s'`)
+	c.Check(shellQuote(""), Equals, "''")
+}
+
+func (s *ShellSuite) TestBashScript(c *C) {
+	script := BashScript("my-prog", "/opt/my tools/my-prog")
+	c.Check(strings.Contains(script, `'/opt/my tools/my-prog' -do-completion`), Equals, true)
+	c.Check(strings.Contains(script, "complete -o default -F _go_cli_complete_my_prog 'my-prog'\n"), Equals, true)
+	c.Check(strings.Contains(script, "_go_cli_complete_my_prog() {"), Equals, true)
+	c.Check(strings.Contains(script, "compopt -o filenames"), Equals, true)
+	c.Check(strings.Contains(script, "compopt -o nospace"), Equals, true)
+	c.Check(strings.Contains(script, "compopt +o default"), Equals, true)
+	c.Check(strings.Contains(script, `COMP_WORDBREAKS="$COMP_WORDBREAKS"`), Equals, true)
+	c.Check(strings.Contains(script, `COMP_KEY="$COMP_KEY"`), Equals, true)
+}
+
+func (s *ShellSuite) TestLineEnv(c *C) {
+	defer SetLineEnv("COMP_LINE", "COMP_POINT")
+	defer os.Unsetenv(scriptLineEnv)
+	os.Unsetenv(scriptLineEnv)
+
+	line, point := lineEnvNames()
+	c.Check([]string{line, point}, DeepEquals, []string{"COMP_LINE", "COMP_POINT"})
+	SetLineEnv("MY_LINE", "MY_POINT")
+	line, point = lineEnvNames()
+	c.Check([]string{line, point}, DeepEquals, []string{"MY_LINE", "MY_POINT"})
+
+	os.Setenv(scriptLineEnv, "prog x")
+	line, point = lineEnvNames()
+	c.Check([]string{line, point}, DeepEquals, []string{"GO_CLI_COMP_LINE", "GO_CLI_COMP_POINT"})
+
+	c.Check(strings.Contains(ZshScript("prog", "prog"), `GO_CLI_COMP_LINE="$LBUFFER" GO_CLI_COMP_POINT="$point"`), Equals, true)
+	c.Check(strings.Contains(FishScript("prog", "prog"), `GO_CLI_COMP_LINE="$line" GO_CLI_COMP_POINT=(`), Equals, true)
+	c.Check(strings.Contains(BashScript("prog", "prog"), `COMP_LINE="$COMP_LINE" COMP_POINT="$COMP_POINT"`), Equals, true)
+}
+
+func (s *ShellSuite) TestSignal(c *C) {
+	resetDirectives()
+	c.Check(currentDirectives(), Equals, Directive(0))
+	Signal(FileResults)
+	Signal(FileResults)
+	c.Check(currentDirectives(), Equals, FileResults)
+	resetDirectives()
+	c.Check(currentDirectives(), Equals, Directive(0))
+}
+
+func (s *ShellSuite) TestEscape(c *C) {
+	c.Check(Bash.Escape("plain-word_1.0,x=y:z"), Equals, "plain-word_1.0,x=y:z")
+	c.Check(Bash.Escape("deploy prod"), Equals, `deploy\ prod`)
+	c.Check(Bash.Escape(`it's "$HOME"`), Equals, `it\'s\ \"\$HOME\"`)
+	c.Check(Bash.Escape("*.go"), Equals, `\*.go`)
+	c.Check(Shell("").Escape("a b"), Equals, `a\ b`)
+	c.Check(Zsh.Escape("a b"), Equals, "a b")
+	c.Check(Fish.Escape("a b"), Equals, "a b")
+}
+
+func (s *ShellSuite) TestActiveShell(c *C) {
+	defer os.Unsetenv(shellEnv)
+	os.Unsetenv(shellEnv)
+	c.Check(activeShell(), Equals, Bash)
+	os.Setenv(shellEnv, "zsh")
+	c.Check(activeShell(), Equals, Zsh)
+	os.Setenv(shellEnv, "fish")
+	c.Check(activeShell(), Equals, Fish)
+	os.Setenv(shellEnv, "tcsh")
+	c.Check(activeShell(), Equals, Bash)
+}
+
+func (s *ShellSuite) TestCompletionOutput(c *C) {
+	words := []string{"a b", "c"}
+	c.Check(completionOutput(words, 0, Bash, false), DeepEquals, []string{`a\ b`, "c"})
+	c.Check(completionOutput(words, NoSpace, Bash, true), DeepEquals, []string{"2", `a\ b`, "c"})
+	c.Check(completionOutput(words, FileResults, Bash, true), DeepEquals, []string{"1", "a b", "c"})
+	c.Check(completionOutput(words, FileResults, Bash, false), DeepEquals, []string{`a\ b`, "c"})
+	c.Check(completionOutput(words, 0, Zsh, true), DeepEquals, []string{"0", "a b", "c"})
+	c.Check(completionOutput(nil, 0, Fish, true), DeepEquals, []string{"0"})
+}
+
+func (s *ShellSuite) TestDescriptions(c *C) {
+	words := []string{Describe("build", "Build the project"), "clean", Describe("deploy-all", "Deploy")}
+	c.Check(completionOutput(words, 0, Bash, false), DeepEquals, []string{"build", "clean", "deploy-all"})
+	c.Check(completionOutput(words, 0, Bash, true), DeepEquals, []string{"0", "build", "clean", "deploy-all"})
+	c.Check(completionOutput(words, 0, Fish, true), DeepEquals,
+		[]string{"0", "build\tBuild the project", "clean", "deploy-all\tDeploy"})
+	c.Check(completionOutput(words, 0, Zsh, true), DeepEquals,
+		[]string{"0", "build\tbuild -- Build the project", "clean", "deploy-all\tdeploy-all -- Deploy"})
+
+	defer SetAlignDescriptions(false)
+	defer os.Unsetenv("COLUMNS")
+	os.Unsetenv("COLUMNS")
+	SetAlignDescriptions(true)
+	c.Check(completionOutput(words, 0, Zsh, true), DeepEquals,
+		[]string{"0", "build\tbuild      -- Build the project", "clean", "deploy-all\tdeploy-all -- Deploy"})
+	c.Check(completionOutput(words, 0, Fish, true), DeepEquals,
+		[]string{"0", "build\tBuild the project", "clean", "deploy-all\tDeploy"})
+	c.Check(completionOutput(words, 0, Bash, false), DeepEquals, []string{"build", "clean", "deploy-all"})
+	os.Setenv("COLUMNS", "16")
+	c.Check(completionOutput(words, 0, Zsh, true), DeepEquals,
+		[]string{"0", "build\tbuild    -- Build the project", "clean", "deploy-all\tdeploy-all -- Deploy"})
+
+	completion, desc := splitDescription(Describe("a", "b\tc"))
+	c.Check(completion, Equals, "a")
+	c.Check(desc, Equals, "b\tc")
+}
+
+func (s *ShellSuite) TestBashCompletions(c *C) {
+	words := []string{"deploy prod", "deploy staging"}
+	c.Check(bashCompletions(`deploy\ p`, words, defaultWordbreaks), DeepEquals, words)
+	c.Check(bashCompletions(`"deploy p`, words, defaultWordbreaks), DeepEquals, words)
+	c.Check(bashCompletions(`de'ploy p`, words, defaultWordbreaks), DeepEquals, []string{"ploy prod", "ploy staging"})
+	c.Check(bashCompletions(`host:"p`, []string{"host:port"}, defaultWordbreaks), DeepEquals, []string{"port"})
+}
+
+func (s *ShellSuite) TestEscaper(c *C) {
+	word := `a "$b"`
+	c.Check(Bash.escaper(0, 0, false)(word), Equals, `a\ \"\$b\"`)
+	c.Check(Bash.escaper('"', 0, true)(word), Equals, `a \"\$b\"`)
+	c.Check(Bash.escaper('\'', 0, true)(word), Equals, word)
+	c.Check(Bash.escaper(0, FileResults, true)(word), Equals, word)
+	c.Check(Bash.escaper(0, FileResults, false)(word), Equals, `a\ \"\$b\"`)
+	c.Check(Zsh.escaper('"', 0, true)(word), Equals, word)
+}
+
+func (s *ShellSuite) TestTrimWordbreaks(c *C) {
+	words := []string{"host:port", "host:path"}
+	c.Check(trimWordbreaks("host:p", words, defaultWordbreaks), DeepEquals, []string{"port", "path"})
+	c.Check(trimWordbreaks("host", words, defaultWordbreaks), DeepEquals, words)
+	c.Check(trimWordbreaks("host:p", words, " \t\n"), DeepEquals, words)
+	c.Check(trimWordbreaks("--mode=par", []string{"--mode=parallel"}, defaultWordbreaks),
+		DeepEquals, []string{"parallel"})
+	c.Check(trimWordbreaks("a=b:c", []string{"a=b:cd", "x"}, defaultWordbreaks), DeepEquals, []string{"cd", "x"})
+	c.Check(trimWordbreaks("'a b", []string{"'a bc"}, defaultWordbreaks), DeepEquals, []string{"'a bc"})
+	c.Check(trimWordbreaks(`a\:b`, []string{"a:bc"}, defaultWordbreaks), DeepEquals, []string{"a:bc"})
+	c.Check(trimWordbreaks(`'a:b'c`, []string{"a:bcd"}, defaultWordbreaks), DeepEquals, []string{"a:bcd"})
+	c.Check(trimWordbreaks(`my\ host:p`, []string{"my host:port"}, defaultWordbreaks), DeepEquals, []string{"port"})
+
+	defer os.Unsetenv("COMP_WORDBREAKS")
+	defer os.Unsetenv(shellEnv)
+	os.Unsetenv(shellEnv)
+	os.Unsetenv("COMP_WORDBREAKS")
+	completer := SetCompleter([]string{"key=value", "key=other"})
+	c.Check(RunCompletion(completer, "prog key=v", 10), DeepEquals, []string{"value"})
+	os.Setenv("COMP_WORDBREAKS", " \t\n")
+	c.Check(RunCompletion(completer, "prog key=v", 10), DeepEquals, []string{"key=value"})
+	os.Setenv(shellEnv, "zsh")
+	os.Unsetenv("COMP_WORDBREAKS")
+	c.Check(RunCompletion(completer, "prog key=v", 10), DeepEquals, []string{"0", "key=value"})
+}
+
+func (s *ShellSuite) TestOtherShellScripts(c *C) {
+	script := ZshScript("my-prog", "/opt/my tools/my-prog")
+	c.Check(strings.Contains(script, `GO_CLI_COMPLETION_SHELL=zsh`), Equals, true)
+	c.Check(strings.Contains(script, `'/opt/my tools/my-prog' -do-completion`), Equals, true)
+	c.Check(strings.Contains(script, "compdef _go_cli_complete_my_prog 'my-prog'\n"), Equals, true)
+	c.Check(strings.Contains(script, `compadd -U -d displays "${opts[@]}" -- "${candidates[@]}"`), Equals, true)
+
+	script = FishScript("my-prog", "/opt/it's/my-prog")
+	c.Check(strings.Contains(script, `GO_CLI_COMPLETION_SHELL=fish`), Equals, true)
+	c.Check(strings.Contains(script, `'/opt/it\'s/my-prog' -do-completion`), Equals, true)
+	c.Check(strings.Contains(script, "complete -c 'my-prog' -f -a '(__go_cli_complete_my_prog)'\n"), Equals, true)
+}
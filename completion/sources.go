@@ -0,0 +1,398 @@
+package completion
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// readLines reads the non-blank, non-comment lines of a file, with
+// surrounding whitespace removed.
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+type fileLinesCompleter string
+
+func (c fileLinesCompleter) Complete(cl CommandLine) []string {
+	lines, err := readLines(string(c))
+	if err != nil {
+		return nil
+	}
+	return setCompleter(lines).Complete(cl)
+}
+
+// FileLinesCompleter returns a Completer that completes from the
+// lines of a file, which is read each time completion is performed.
+// Blank lines and lines starting with `#' are ignored. If the file
+// can't be read, there are no completions.
+func FileLinesCompleter(path string) Completer {
+	return fileLinesCompleter(path)
+}
+
+// makeTargets returns the targets defined in a Makefile. Targets
+// declared .PHONY come first, followed by the others in the order
+// they appear. Pattern rules, special targets such as .PHONY, and
+// targets involving variables are omitted.
+func makeTargets(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var phony, others []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "\t") {
+			// A recipe line.
+			continue
+		}
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 || strings.Contains(line[:i], "=") {
+			continue
+		}
+		rest := line[i+1:]
+		if strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, ":=") {
+			// A variable assignment.
+			continue
+		}
+		targets := strings.Fields(line[:i])
+		if len(targets) == 1 && targets[0] == ".PHONY" {
+			phony = append(phony, strings.Fields(strings.TrimPrefix(rest, ":"))...)
+			continue
+		}
+		others = append(others, targets...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+	for _, t := range append(phony, others...) {
+		if seen[t] || strings.HasPrefix(t, ".") || strings.ContainsAny(t, "%$") {
+			continue
+		}
+		seen[t] = true
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+type makeTargetCompleter string
+
+func (c makeTargetCompleter) Complete(cl CommandLine) []string {
+	targets, err := makeTargets(filepath.Join(string(c), "Makefile"))
+	if err != nil {
+		return nil
+	}
+	return setCompleter(targets).Complete(cl)
+}
+
+// MakeTargetCompleter returns a Completer for the targets defined in
+// the Makefile in dir, for programs that wrap make. Targets declared
+// .PHONY, which are usually the ones meant to be run by hand, are
+// offered first. Pattern rules and special targets, such as
+// .PHONY itself, are not offered. If there is no Makefile, there are
+// no completions.
+func MakeTargetCompleter(dir string) Completer {
+	return makeTargetCompleter(dir)
+}
+
+// plugins returns the names, without prefix, of the executables in
+// the directories of $PATH whose names start with prefix.
+func plugins(prefix string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			name := info.Name()
+			if !strings.HasPrefix(name, prefix) || len(name) == len(prefix) {
+				continue
+			}
+			plugin := name[len(prefix):]
+			if seen[plugin] {
+				continue
+			}
+			// Stat the file to follow symlinks.
+			if info, err = os.Stat(filepath.Join(dir, name)); err != nil {
+				continue
+			}
+			if info.IsDir() || info.Mode()&0111 == 0 {
+				continue
+			}
+			seen[plugin] = true
+			names = append(names, plugin)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+type pluginCompleter string
+
+func (c pluginCompleter) Complete(cl CommandLine) []string {
+	return setCompleter(plugins(string(c))).Complete(cl)
+}
+
+// PluginCompleter returns a Completer for the subcommands of a
+// program that are implemented as separate executables, in the style
+// of git: those named prefix followed by the subcommand, such as
+// "tool-deploy" for the prefix "tool-". The directories of $PATH are
+// searched each time completion is performed, and the subcommand
+// names are offered in lexical order.
+func PluginCompleter(prefix string) Completer {
+	return pluginCompleter(prefix)
+}
+
+// MaxHistory is the number of values RecordValue keeps in a history
+// file; older values are dropped.
+const MaxHistory = 100
+
+// readHistory returns the values in a history file, most recent
+// first, without duplicates. A missing file has no values.
+func readHistory(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	seen := make(map[string]bool)
+	var values []string
+	for i := len(lines) - 1; i >= 0; i-- {
+		if v := lines[i]; v != "" && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+type historyCompleter struct {
+	path string
+	max  int
+}
+
+func (c historyCompleter) Complete(cl CommandLine) []string {
+	values, err := readHistory(c.path)
+	if err != nil {
+		return nil
+	}
+	if c.max > 0 && len(values) > c.max {
+		values = values[:c.max]
+	}
+	return setCompleter(values).Complete(cl)
+}
+
+// HistoryCompleter returns a Completer that completes from the values
+// recently recorded in a history file by RecordValue, most recent
+// first. At most max of the most recent values are considered; a max
+// of zero or less considers every value in the file, of which
+// RecordValue keeps at most MaxHistory. If the file can't be read,
+// there are no completions.
+func HistoryCompleter(path string, max int) Completer {
+	return historyCompleter{path, max}
+}
+
+// RecordValue records a value in a history file for use by
+// HistoryCompleter, typically after a run of the program that used
+// it succeeded. The value becomes the most recent one, replacing any
+// earlier occurrence of it, and the file is trimmed to the MaxHistory
+// most recent values. Values may not contain newlines.
+func RecordValue(path, value string) error {
+	if value == "" {
+		return nil
+	}
+	if strings.Contains(value, "\n") {
+		return fmt.Errorf("completion: history value contains a newline: %q", value)
+	}
+	values, err := readHistory(path)
+	if err != nil {
+		return err
+	}
+	lines := []string{value}
+	for _, v := range values {
+		if len(lines) == MaxHistory {
+			break
+		}
+		if v != value {
+			lines = append(lines, v)
+		}
+	}
+	// The file lists values oldest first.
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+// jsonKeys returns the keys, in lexical order, of the object at a
+// dotted path in a JSON file. The empty path denotes the top-level
+// object.
+func jsonKeys(path, jsonPath string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	if jsonPath != "" {
+		for _, key := range strings.Split(jsonPath, ".") {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: no object at %s", path, jsonPath)
+			}
+			v = obj[key]
+		}
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: no object at %s", path, jsonPath)
+	}
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+type jsonKeyCompleter struct {
+	path, jsonPath string
+}
+
+func (c jsonKeyCompleter) Complete(cl CommandLine) []string {
+	keys, err := jsonKeys(c.path, c.jsonPath)
+	if err != nil {
+		return nil
+	}
+	return setCompleter(keys).Complete(cl)
+}
+
+// JSONKeyCompleter returns a Completer for the keys of an object in a
+// JSON file, for tools whose arguments refer to entries in a JSON
+// document. jsonPath is the dotted path to the object from the
+// top-level one, such as "profiles" or "servers.prod"; the empty path
+// denotes the top-level object itself. The keys are offered in
+// lexical order. The file is read each time completion is performed;
+// if it can't be read or parsed, or there is no object at jsonPath,
+// there are no completions.
+func JSONKeyCompleter(path, jsonPath string) Completer {
+	return jsonKeyCompleter{path, jsonPath}
+}
+
+// A GitStatusFilter selects the files offered by GitStatusCompleter.
+type GitStatusFilter int
+
+const (
+	// GitChanged selects all files git status reports: those with
+	// changes, staged or not, and untracked ones.
+	GitChanged GitStatusFilter = iota
+	// GitModified selects tracked files with changes, staged or
+	// not, including deleted and renamed ones.
+	GitModified
+	// GitUntracked selects untracked files that aren't ignored.
+	GitUntracked
+)
+
+// gitStatus returns the files under dir that git status reports and
+// filter selects, relative to dir.
+func gitStatus(dir string, filter GitStatusFilter) ([]string, error) {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSpace(string(out))
+	out, err = exec.Command("git", "-C", dir, "status",
+		"--porcelain", "-z", "--untracked-files=all", "--", ".").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	// With -z, each entry is of the form "XY path", terminated by a
+	// NUL and with the path unquoted. X and Y are the file's status
+	// in the index and the work tree, and are both `?' for untracked
+	// files. The path is relative to the top of the repository. A
+	// rename or copy is followed by a second entry, holding just the
+	// original path.
+	var paths []string
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		status, path := entry[:2], entry[3:]
+		if status[0] == 'R' || status[0] == 'C' {
+			i++
+		}
+		untracked := status == "??"
+		if (filter == GitModified && untracked) || (filter == GitUntracked && !untracked) {
+			continue
+		}
+		paths = append(paths, strings.TrimPrefix(path, prefix))
+	}
+	return paths, nil
+}
+
+type gitStatusCompleter struct {
+	dir    string
+	filter GitStatusFilter
+}
+
+func (c gitStatusCompleter) Complete(cl CommandLine) []string {
+	paths, err := gitStatus(c.dir, c.filter)
+	if err != nil {
+		return nil
+	}
+	return setCompleter(paths).Complete(cl)
+}
+
+// GitStatusCompleter returns a Completer for the paths of the files
+// under repoDir, in a git work tree, that `git status' reports, for
+// tools whose arguments are usually files being worked on. filter
+// selects which of them are offered. The paths are relative to
+// repoDir, and are offered in the order git lists them. git is run
+// each time completion is performed; if it fails, as when repoDir
+// isn't in a work tree, there are no completions.
+func GitStatusCompleter(repoDir string, filter GitStatusFilter) Completer {
+	return gitStatusCompleter{repoDir, filter}
+}
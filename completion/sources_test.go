@@ -0,0 +1,210 @@
+package completion
+
+import (
+	"fmt"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+type SourceSuite struct {
+	dir string
+}
+
+var _ = Suite(&SourceSuite{})
+
+func (s *SourceSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *SourceSuite) write(c *C, name, contents string) string {
+	path := filepath.Join(s.dir, name)
+	c.Assert(ioutil.WriteFile(path, []byte(contents), 0644), IsNil)
+	return path
+}
+
+func (s *SourceSuite) TestFileLinesCompleter(c *C) {
+	path := s.write(c, "projects", ""+
+		"# known projects\n"+
+		"  alpha  \n"+
+		"\n"+
+		"beta\n"+
+		"alphabet\n")
+	completer := FileLinesCompleter(path)
+	c.Check(completer.Complete(CommandLine{""}), DeepEquals, []string{"alpha", "beta", "alphabet"})
+	c.Check(completer.Complete(CommandLine{"al"}), DeepEquals, []string{"alpha", "alphabet"})
+	c.Check(completer.Complete(CommandLine{"#"}), IsNil)
+
+	c.Check(FileLinesCompleter(filepath.Join(s.dir, "missing")).Complete(CommandLine{""}), IsNil)
+}
+
+func (s *SourceSuite) TestMakeTargetCompleter(c *C) {
+	s.write(c, "Makefile", ""+
+		"# Build the project.\n"+
+		"CC := gcc\n"+
+		"FLAGS = -O2 -DX=a:b\n"+
+		"OUT ::= out\n"+
+		"\n"+
+		"all: build\n"+
+		"build: main.o util.o # link\n"+
+		"\t$(CC) -o app main.o util.o\n"+
+		"\techo done: ok\n"+
+		"%.o: %.c\n"+
+		"\t$(CC) -c $<\n"+
+		"$(OUT)/x: build\n"+
+		"main.o util.o: common.h\n"+
+		"install:: build\n"+
+		".PHONY: test clean\n"+
+		".PHONY: all\n"+
+		".SUFFIXES:\n"+
+		"test: build\n"+
+		"clean:\n")
+	completer := MakeTargetCompleter(s.dir)
+	c.Check(completer.Complete(CommandLine{""}), DeepEquals,
+		[]string{"test", "clean", "all", "build", "main.o", "util.o", "install"})
+	c.Check(completer.Complete(CommandLine{"b"}), DeepEquals, []string{"build"})
+	c.Check(completer.Complete(CommandLine{"x"}), IsNil)
+
+	c.Check(MakeTargetCompleter(filepath.Join(s.dir, "missing")).Complete(CommandLine{""}), IsNil)
+}
+
+func (s *SourceSuite) TestPluginCompleter(c *C) {
+	bin1 := filepath.Join(s.dir, "bin1")
+	bin2 := filepath.Join(s.dir, "bin2")
+	c.Assert(os.Mkdir(bin1, 0755), IsNil)
+	c.Assert(os.Mkdir(bin2, 0755), IsNil)
+	for path, mode := range map[string]os.FileMode{
+		"bin1/tool-deploy": 0755,
+		"bin1/tool-build":  0755,
+		"bin1/tool-notes":  0644,
+		"bin1/tool-":       0755,
+		"bin1/other":       0755,
+		"bin2/tool-deploy": 0755,
+		"bin2/tool-bench":  0700,
+	} {
+		c.Assert(ioutil.WriteFile(filepath.Join(s.dir, path), nil, mode), IsNil)
+	}
+	c.Assert(os.Mkdir(filepath.Join(bin2, "tool-dir"), 0755), IsNil)
+	c.Assert(os.Symlink(filepath.Join(bin1, "tool-build"), filepath.Join(bin2, "tool-link")), IsNil)
+
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", bin1+string(filepath.ListSeparator)+
+		filepath.Join(s.dir, "missing")+string(filepath.ListSeparator)+bin2)
+
+	completer := PluginCompleter("tool-")
+	c.Check(completer.Complete(CommandLine{""}), DeepEquals, []string{"bench", "build", "deploy", "link"})
+	c.Check(completer.Complete(CommandLine{"b"}), DeepEquals, []string{"bench", "build"})
+	c.Check(completer.Complete(CommandLine{"x"}), IsNil)
+}
+
+func (s *SourceSuite) TestHistoryCompleter(c *C) {
+	path := filepath.Join(s.dir, "history")
+	completer := HistoryCompleter(path, 3)
+	c.Check(completer.Complete(CommandLine{""}), IsNil)
+
+	for _, v := range []string{"alpha", "beta", "alpha.example.com", "gamma", "beta", ""} {
+		c.Assert(RecordValue(path, v), IsNil)
+	}
+	contents, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Check(string(contents), Equals, "alpha\nalpha.example.com\ngamma\nbeta\n")
+
+	c.Check(completer.Complete(CommandLine{""}), DeepEquals, []string{"beta", "gamma", "alpha.example.com"})
+	c.Check(completer.Complete(CommandLine{"al"}), DeepEquals, []string{"alpha.example.com"})
+	c.Check(HistoryCompleter(path, 10).Complete(CommandLine{"al"}), DeepEquals, []string{"alpha.example.com", "alpha"})
+
+	c.Check(RecordValue(path, "two\nlines"), ErrorMatches, "completion: history value contains a newline: .*")
+}
+
+func (s *SourceSuite) TestHistoryTrimmed(c *C) {
+	path := filepath.Join(s.dir, "history")
+	for i := 0; i < MaxHistory+5; i++ {
+		c.Assert(RecordValue(path, fmt.Sprint(i)), IsNil)
+	}
+	values, err := readHistory(path)
+	c.Assert(err, IsNil)
+	c.Check(len(values), Equals, MaxHistory)
+	c.Check(values[0], Equals, fmt.Sprint(MaxHistory+4))
+	c.Check(HistoryCompleter(path, 0).Complete(CommandLine{""}), DeepEquals, values)
+}
+
+func (s *SourceSuite) TestHistoryUnlimited(c *C) {
+	path := filepath.Join(s.dir, "history")
+	for i := 0; i < 5; i++ {
+		c.Assert(RecordValue(path, fmt.Sprint(i)), IsNil)
+	}
+	want := []string{"4", "3", "2", "1", "0"}
+	c.Check(HistoryCompleter(path, -1).Complete(CommandLine{""}), DeepEquals, want)
+	c.Check(HistoryCompleter(path, 0).Complete(CommandLine{""}), DeepEquals, want)
+	c.Check(HistoryCompleter(path, 2).Complete(CommandLine{""}), DeepEquals, want[:2])
+}
+
+func (s *SourceSuite) TestJSONKeyCompleter(c *C) {
+	path := s.write(c, "config.json", `{
+		"profiles": {"prod": {"region": "us"}, "preview": {}, "dev": {}},
+		"servers": {"eu": {"primary": {"host": "a"}, "backup": {}}},
+		"version": 2,
+		"list": [1, 2]
+	}`)
+
+	c.Check(JSONKeyCompleter(path, "").Complete(CommandLine{""}), DeepEquals,
+		[]string{"list", "profiles", "servers", "version"})
+	c.Check(JSONKeyCompleter(path, "profiles").Complete(CommandLine{"pr"}), DeepEquals,
+		[]string{"preview", "prod"})
+	c.Check(JSONKeyCompleter(path, "servers.eu").Complete(CommandLine{""}), DeepEquals,
+		[]string{"backup", "primary"})
+	c.Check(JSONKeyCompleter(path, "profiles.dev").Complete(CommandLine{""}), IsNil)
+
+	for _, jsonPath := range []string{"version", "list", "missing", "profiles.prod.region.x"} {
+		c.Check(JSONKeyCompleter(path, jsonPath).Complete(CommandLine{""}), IsNil,
+			Commentf("path: %s", jsonPath))
+	}
+	c.Check(JSONKeyCompleter(filepath.Join(s.dir, "missing.json"), "").Complete(CommandLine{""}), IsNil)
+	bad := s.write(c, "bad.json", "{")
+	c.Check(JSONKeyCompleter(bad, "").Complete(CommandLine{""}), IsNil)
+}
+
+func (s *SourceSuite) git(c *C, args ...string) {
+	args = append([]string{"-C", s.dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	out, err := exec.Command("git", args...).CombinedOutput()
+	c.Assert(err, IsNil, Commentf("git %q: %s", args, out))
+}
+
+func (s *SourceSuite) TestGitStatusCompleter(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+	c.Check(GitStatusCompleter(s.dir, GitChanged).Complete(CommandLine{""}), IsNil)
+
+	s.git(c, "init", "-q")
+	c.Assert(os.Mkdir(filepath.Join(s.dir, "src"), 0755), IsNil)
+	for _, name := range []string{"README", "old", "src/main.go", "src/util.go", "staged", "gone"} {
+		s.write(c, name, name+"\n")
+	}
+	s.write(c, ".gitignore", "*.o\n")
+	s.git(c, "add", ".")
+	s.git(c, "commit", "-q", "-m", "initial")
+
+	s.write(c, "src/main.go", "changed\n")
+	s.write(c, "staged", "changed\n")
+	s.git(c, "add", "staged")
+	s.git(c, "mv", "old", "new name")
+	c.Assert(os.Remove(filepath.Join(s.dir, "gone")), IsNil)
+	s.write(c, "src/extra.go", "\n")
+	s.write(c, "notes", "\n")
+	s.write(c, "src/main.o", "\n")
+
+	changed := GitStatusCompleter(s.dir, GitChanged)
+	c.Check(changed.Complete(CommandLine{""}), DeepEquals,
+		[]string{"gone", "new name", "src/main.go", "staged", "notes", "src/extra.go"})
+	c.Check(changed.Complete(CommandLine{"src/"}), DeepEquals, []string{"src/main.go", "src/extra.go"})
+	c.Check(changed.Complete(CommandLine{"README"}), IsNil)
+	c.Check(GitStatusCompleter(s.dir, GitModified).Complete(CommandLine{""}), DeepEquals,
+		[]string{"gone", "new name", "src/main.go", "staged"})
+	c.Check(GitStatusCompleter(s.dir, GitUntracked).Complete(CommandLine{""}), DeepEquals,
+		[]string{"notes", "src/extra.go"})
+	c.Check(GitStatusCompleter(filepath.Join(s.dir, "src"), GitChanged).Complete(CommandLine{""}), DeepEquals,
+		[]string{"main.go", "extra.go"})
+}
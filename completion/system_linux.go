@@ -0,0 +1,148 @@
+package completion
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procMounts and sysBlock are the sources of MountCompleter and
+// BlockDeviceCompleter; tests replace them.
+var (
+	procMounts = "/proc/mounts"
+	sysBlock   = "/sys/class/block"
+)
+
+// pseudoFilesystems lists the types of the filesystems, not backed
+// by storage, that MountCompleter skips. tmpfs and overlay are not
+// included, since they are often mounted where users keep files.
+var pseudoFilesystems = map[string]bool{
+	"autofs":      true,
+	"binfmt_misc": true,
+	"bpf":         true,
+	"cgroup":      true,
+	"cgroup2":     true,
+	"configfs":    true,
+	"debugfs":     true,
+	"devpts":      true,
+	"devtmpfs":    true,
+	"efivarfs":    true,
+	"fusectl":     true,
+	"hugetlbfs":   true,
+	"mqueue":      true,
+	"nsfs":        true,
+	"proc":        true,
+	"pstore":      true,
+	"rpc_pipefs":  true,
+	"securityfs":  true,
+	"selinuxfs":   true,
+	"sysfs":       true,
+	"tracefs":     true,
+}
+
+// unescapeMount undoes the octal escaping of spaces, tabs,
+// newlines and backslashes in a field of /proc/mounts.
+func unescapeMount(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var buf []byte
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+4 <= len(field) {
+			if n, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				buf = append(buf, byte(n))
+				i += 3
+				continue
+			}
+		}
+		buf = append(buf, field[i])
+	}
+	return string(buf)
+}
+
+// mountPoints returns the mount points listed in /proc/mounts, in
+// order, omitting those of pseudo-filesystems unless pseudo is set.
+func mountPoints(pseudo bool) ([]string, error) {
+	f, err := os.Open(procMounts)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	var points []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		if pseudoFilesystems[fields[2]] && !pseudo {
+			continue
+		}
+		point := unescapeMount(fields[1])
+		if seen[point] {
+			continue
+		}
+		seen[point] = true
+		points = append(points, point)
+	}
+	return points, scanner.Err()
+}
+
+type mountCompleter bool
+
+func (c mountCompleter) Complete(cl CommandLine) []string {
+	points, err := mountPoints(bool(c))
+	if err != nil {
+		return nil
+	}
+	return setCompleter(points).Complete(cl)
+}
+
+// MountCompleter returns a Completer for the mount points of the
+// filesystems listed in /proc/mounts, for options such as --mount.
+// Pseudo-filesystems, such as /proc and /sys, are skipped; see
+// AllMountsCompleter. The mount points are offered in the order they
+// were mounted. /proc/mounts is read each time completion is
+// performed.
+//
+// MountCompleter is only supported on Linux; elsewhere, there are no
+// completions.
+func MountCompleter() Completer {
+	return mountCompleter(false)
+}
+
+// AllMountsCompleter is like MountCompleter, but also offers the
+// mount points of pseudo-filesystems.
+func AllMountsCompleter() Completer {
+	return mountCompleter(true)
+}
+
+type blockDeviceCompleter struct{}
+
+func (blockDeviceCompleter) Complete(cl CommandLine) []string {
+	infos, err := ioutil.ReadDir(sysBlock)
+	if err != nil {
+		return nil
+	}
+	devices := make([]string, 0, len(infos))
+	for _, info := range infos {
+		devices = append(devices, filepath.Join("/dev", info.Name()))
+	}
+	return setCompleter(devices).Complete(cl)
+}
+
+// BlockDeviceCompleter returns a Completer for the paths of the block
+// devices, such as /dev/sda and its partitions, that the kernel
+// lists in /sys/class/block, for options such as --device. They are
+// offered in lexical order.
+//
+// BlockDeviceCompleter is only supported on Linux; elsewhere, there
+// are no completions.
+func BlockDeviceCompleter() Completer {
+	return blockDeviceCompleter{}
+}
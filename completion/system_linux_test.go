@@ -0,0 +1,66 @@
+package completion
+
+import (
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+)
+
+type SystemSuite struct {
+	dir string
+}
+
+var _ = Suite(&SystemSuite{})
+
+func (s *SystemSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *SystemSuite) TearDownTest(c *C) {
+	procMounts = "/proc/mounts"
+	sysBlock = "/sys/class/block"
+}
+
+func (s *SystemSuite) TestMountCompleter(c *C) {
+	procMounts = filepath.Join(s.dir, "mounts")
+	c.Assert(ioutil.WriteFile(procMounts, []byte(""+
+		"/dev/vda1 / ext4 rw,relatime 0 0\n"+
+		"proc /proc proc rw,nosuid 0 0\n"+
+		"sysfs /sys sysfs rw,nosuid 0 0\n"+
+		"tmpfs /tmp tmpfs rw 0 0\n"+
+		"/dev/vdb1 /mnt/my\\040disk ext4 rw 0 0\n"+
+		"/dev/vdb2 /mnt/data xfs rw 0 0\n"+
+		"cgroup2 /sys/fs/cgroup cgroup2 rw 0 0\n"+
+		"/dev/vdb3 /mnt/data xfs rw 0 0\n"+
+		"bogus\n"), 0644), IsNil)
+
+	c.Check(MountCompleter().Complete(CommandLine{""}), DeepEquals,
+		[]string{"/", "/tmp", "/mnt/my disk", "/mnt/data"})
+	c.Check(MountCompleter().Complete(CommandLine{"/mnt/"}), DeepEquals, []string{"/mnt/my disk", "/mnt/data"})
+	c.Check(MountCompleter().Complete(CommandLine{"/s"}), IsNil)
+	c.Check(AllMountsCompleter().Complete(CommandLine{"/s"}), DeepEquals, []string{"/sys", "/sys/fs/cgroup"})
+
+	procMounts = filepath.Join(s.dir, "missing")
+	c.Check(MountCompleter().Complete(CommandLine{""}), IsNil)
+}
+
+func (s *SystemSuite) TestUnescapeMount(c *C) {
+	c.Check(unescapeMount(`/mnt/a\040b\011c\134d`), Equals, "/mnt/a b\tc\\d")
+	c.Check(unescapeMount(`/mnt/a\0`), Equals, `/mnt/a\0`)
+	c.Check(unescapeMount(`/mnt/a\999`), Equals, `/mnt/a\999`)
+}
+
+func (s *SystemSuite) TestBlockDeviceCompleter(c *C) {
+	sysBlock = s.dir
+	for _, name := range []string{"vda", "vda1", "vdb", "loop0"} {
+		c.Assert(os.Symlink("../../devices/"+name, filepath.Join(s.dir, name)), IsNil)
+	}
+	c.Check(BlockDeviceCompleter().Complete(CommandLine{""}), DeepEquals,
+		[]string{"/dev/loop0", "/dev/vda", "/dev/vda1", "/dev/vdb"})
+	c.Check(BlockDeviceCompleter().Complete(CommandLine{"/dev/vda"}), DeepEquals, []string{"/dev/vda", "/dev/vda1"})
+	c.Check(BlockDeviceCompleter().Complete(CommandLine{"vda"}), IsNil)
+
+	sysBlock = filepath.Join(s.dir, "missing")
+	c.Check(BlockDeviceCompleter().Complete(CommandLine{""}), IsNil)
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package completion
+
+// MountCompleter returns a Completer for mount points. It is only
+// supported on Linux; elsewhere, there are no completions.
+func MountCompleter() Completer {
+	return setCompleter(nil)
+}
+
+// AllMountsCompleter is like MountCompleter, but also offers the
+// mount points of pseudo-filesystems. It is only supported on Linux;
+// elsewhere, there are no completions.
+func AllMountsCompleter() Completer {
+	return setCompleter(nil)
+}
+
+// BlockDeviceCompleter returns a Completer for the paths of block
+// devices. It is only supported on Linux; elsewhere, there are no
+// completions.
+func BlockDeviceCompleter() Completer {
+	return setCompleter(nil)
+}
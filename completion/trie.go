@@ -0,0 +1,81 @@
+package completion
+
+import (
+	"sort"
+)
+
+// A trieNode is a node of the prefix trie built by TrieCompleter.
+// Its edges are sorted by byte, so that walking the trie visits words
+// in lexical order.
+type trieNode struct {
+	word  bool
+	edges []trieEdge
+}
+
+type trieEdge struct {
+	b    byte
+	node *trieNode
+}
+
+// child returns the child of n along the edge labeled b, creating it
+// if create is set.
+func (n *trieNode) child(b byte, create bool) *trieNode {
+	i := sort.Search(len(n.edges), func(i int) bool { return n.edges[i].b >= b })
+	if i < len(n.edges) && n.edges[i].b == b {
+		return n.edges[i].node
+	}
+	if !create {
+		return nil
+	}
+	child := new(trieNode)
+	n.edges = append(n.edges, trieEdge{})
+	copy(n.edges[i+1:], n.edges[i:])
+	n.edges[i] = trieEdge{b, child}
+	return child
+}
+
+// collect appends the words in the subtrie rooted at n, each of which
+// starts with prefix, to words.
+func (n *trieNode) collect(prefix []byte, words []string) []string {
+	if n.word {
+		words = append(words, string(prefix))
+	}
+	for _, e := range n.edges {
+		words = e.node.collect(append(prefix, e.b), words)
+	}
+	return words
+}
+
+type trieCompleter struct {
+	root *trieNode
+}
+
+func (c trieCompleter) Complete(cl CommandLine) []string {
+	word := cl.CurrentWord()
+	n := c.root
+	for i := 0; i < len(word) && n != nil; i++ {
+		n = n.child(word[i], false)
+	}
+	if n == nil {
+		return nil
+	}
+	return n.collect([]byte(word), nil)
+}
+
+// TrieCompleter is like SortedSetCompleter, but indexes strs in a
+// prefix trie up front, so that each completion takes time
+// proportional to the length of the word and the number of
+// completions, rather than to the size of the set. It is meant for
+// large sets, of many thousands of words. Duplicates in strs are
+// offered once.
+func TrieCompleter(strs []string) Completer {
+	root := new(trieNode)
+	for _, s := range strs {
+		n := root
+		for i := 0; i < len(s); i++ {
+			n = n.child(s[i], true)
+		}
+		n.word = true
+	}
+	return trieCompleter{root}
+}
@@ -0,0 +1,73 @@
+package completion
+
+import (
+	"fmt"
+	. "launchpad.net/gocheck"
+)
+
+type TrieSuite struct{}
+
+var _ = Suite(&TrieSuite{})
+
+func (s *TrieSuite) TestTrieCompleter(c *C) {
+	words := []string{"zeta", "alpha", "beta", "alphabet", "alpha", "", "ünïcode"}
+	completer := TrieCompleter(words)
+	testCases := []struct {
+		word        string
+		completions []string
+	}{
+		{"", []string{"", "alpha", "alphabet", "beta", "zeta", "ünïcode"}},
+		{"a", []string{"alpha", "alphabet"}},
+		{"alpha", []string{"alpha", "alphabet"}},
+		{"alphab", []string{"alphabet"}},
+		{"alphabets", nil},
+		{"x", nil},
+		{"ü", []string{"ünïcode"}},
+	}
+	for _, tc := range testCases {
+		c.Check(completer.Complete(CommandLine{tc.word}), DeepEquals, tc.completions,
+			Commentf("word: %q", tc.word))
+	}
+	c.Check(TrieCompleter(nil).Complete(CommandLine{""}), IsNil)
+}
+
+func (s *TrieSuite) TestMatchesSortedSetCompleter(c *C) {
+	words := packageNames(2000)
+	trie, set := TrieCompleter(words), SortedSetCompleter(words)
+	for _, word := range []string{"", "g", "github.com/", "github.com/user1", "golang.org/x/pkg19", "none"} {
+		c.Check(trie.Complete(CommandLine{word}), DeepEquals, set.Complete(CommandLine{word}),
+			Commentf("word: %q", word))
+	}
+}
+
+// packageNames returns n distinct import paths, for testing and
+// benchmarking with a large set.
+func packageNames(n int) []string {
+	names := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			names = append(names, fmt.Sprintf("github.com/user%d/pkg%d", i%97, i))
+		} else {
+			names = append(names, fmt.Sprintf("golang.org/x/pkg%d", i))
+		}
+	}
+	return names
+}
+
+// Run the benchmarks with `go test -gocheck.b -gocheck.f Trie'.
+
+func (s *TrieSuite) BenchmarkSortedSetCompleter(c *C) {
+	completer := SortedSetCompleter(packageNames(50000))
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		completer.Complete(CommandLine{"github.com/user42/pkg1"})
+	}
+}
+
+func (s *TrieSuite) BenchmarkTrieCompleter(c *C) {
+	completer := TrieCompleter(packageNames(50000))
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		completer.Complete(CommandLine{"github.com/user42/pkg1"})
+	}
+}
@@ -0,0 +1,344 @@
+package completion
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CompleteList completes one item of a sep-separated list of values,
+// such as the value of a flag taking a comma-separated list. partial
+// is the list entered so far; the items before the final separator
+// are taken to have already been chosen. CompleteList returns the
+// candidates that have not been chosen and that match the final,
+// partial item, each preceded by the items already entered.
+//
+// For example, with candidates "cache", "tls" and "http2", the
+// partial list "cache,t" completes to "cache,tls".
+func CompleteList(candidates []string, partial, sep string) (completions []string) {
+	var head, last string
+	if i := strings.LastIndex(partial, sep); i >= 0 {
+		head, last = partial[:i+len(sep)], partial[i+len(sep):]
+	} else {
+		last = partial
+	}
+
+	chosen := make(map[string]bool)
+	if head != "" {
+		for _, item := range strings.Split(head[:len(head)-len(sep)], sep) {
+			chosen[item] = true
+		}
+	}
+
+	for _, cand := range candidates {
+		if !chosen[cand] && strings.HasPrefix(cand, last) {
+			completions = append(completions, head+cand)
+		}
+	}
+	return completions
+}
+
+type csvSetCompleter []string
+
+func (c csvSetCompleter) Complete(cl CommandLine) []string {
+	completions := CompleteList(c, cl.CurrentWord(), ",")
+	if completions != nil {
+		Signal(NoSpace)
+	}
+	return completions
+}
+
+// CSVSetCompleter returns a Completer for values consisting of a
+// comma-separated list of items from a fixed set, such as
+// "cache,tls,http2". Items already present in the list are not
+// offered again (see CompleteList). The shell is asked not to add a
+// space after a completion, so that the user can go on to add more
+// items.
+func CSVSetCompleter(values []string) Completer {
+	return csvSetCompleter(values)
+}
+
+type rangeCompleter struct {
+	min, max int
+}
+
+func (r rangeCompleter) Complete(cl CommandLine) []string {
+	Signal(NoFileCompletion)
+	word := cl.CurrentWord()
+	completions := []string{}
+	if n, err := strconv.Atoi(word); err == nil && r.min <= n && n <= r.max && strconv.Itoa(n) == word {
+		completions = append(completions, word)
+	}
+	var next []string
+	if word == "" {
+		next = append(next, "-")
+	}
+	for d := '0'; d <= '9'; d++ {
+		next = append(next, word+string(d))
+	}
+	for _, prefix := range next {
+		if r.hasPrefix(prefix) {
+			completions = append(completions, prefix)
+		}
+	}
+	for _, c := range completions {
+		if n, err := strconv.Atoi(c); err != nil || n < r.min || n > r.max {
+			Signal(NoSpace)
+			break
+		}
+	}
+	return completions
+}
+
+// hasPrefix reports whether any number in the range, written in
+// decimal, begins with prefix.
+func (r rangeCompleter) hasPrefix(prefix string) bool {
+	digits := strings.TrimPrefix(prefix, "-")
+	neg := len(digits) < len(prefix)
+
+	// lo and hi bound the magnitudes of the numbers in the range
+	// with the sign of the prefix.
+	var lo, hi uint64
+	if neg {
+		if r.min >= 0 {
+			return false
+		}
+		lo, hi = 1, uint64(-(r.min+1))+1
+		if r.max < 0 {
+			lo = uint64(-(r.max + 1)) + 1
+		}
+	} else {
+		if r.max < 0 {
+			return false
+		}
+		hi = uint64(r.max)
+		if r.min > 0 {
+			lo = uint64(r.min)
+		}
+	}
+
+	if digits == "" {
+		return true
+	}
+	q, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return false
+	}
+	if digits[0] == '0' {
+		return digits == "0" && !neg && lo == 0
+	}
+	// The numbers beginning with q are q itself, then q0 through
+	// q9, then q00 through q99, and so on.
+	for width := uint64(1); q <= hi; width *= 10 {
+		if q+width-1 >= lo {
+			return true
+		}
+		if q > hi/10 {
+			break
+		}
+		q *= 10
+	}
+	return false
+}
+
+// RangeCompleter returns a Completer for integers between min and
+// max, inclusive, such as a port number or a verbosity level. The
+// word being completed is offered if it is in the range, together
+// with each one-digit extension of it that is the start of a number
+// in the range. For a small range such as 0 to 5, this offers every
+// value; for larger ones, the user narrows down the number one digit
+// at a time.
+func RangeCompleter(min, max int) Completer {
+	return rangeCompleter{min, max}
+}
+
+type numRangeCompleter []string
+
+func (c numRangeCompleter) Complete(cl CommandLine) []string {
+	Signal(NoFileCompletion)
+	return setCompleter(c).Complete(cl)
+}
+
+// NumRangeCompleter returns a Completer for the integers from min to
+// max, inclusive, in increments of step, such as a quality setting of
+// 10, 20, ..., 100. If step doesn't divide the range evenly, the last
+// value is the largest one not exceeding max, so max itself may not be
+// offered; if step isn't positive, only min is. Unlike
+// RangeCompleter, it generates every value up front, so it is meant
+// for ranges of at most a few hundred values.
+func NumRangeCompleter(min, max, step int) Completer {
+	var values numRangeCompleter
+	for n := min; n <= max; n += step {
+		values = append(values, strconv.Itoa(n))
+		if step <= 0 || n > max-step {
+			break
+		}
+	}
+	return values
+}
+
+// timeTokens are the relative times offered by TimeCompleter.
+var timeTokens = []string{"today", "yesterday", "1h", "7d"}
+
+// now returns the current time; tests replace it.
+var now = time.Now
+
+type timeCompleter struct{}
+
+func (timeCompleter) Complete(cl CommandLine) []string {
+	Signal(NoFileCompletion)
+	candidates := append([]string{now().Format("2006-01-02")}, timeTokens...)
+	completions := []string{}
+	for _, cand := range candidates {
+		if strings.HasPrefix(cand, cl.CurrentWord()) {
+			completions = append(completions, cand)
+		}
+	}
+	return completions
+}
+
+// TimeCompleter returns a Completer for values denoting a point in
+// time, such as the argument to a --since flag. It offers the
+// current date, in ISO 8601 (YYYY-MM-DD) format, and the relative
+// times "today", "yesterday", "1h" and "7d". Interpreting the values
+// is up to the program.
+func TimeCompleter() Completer {
+	return timeCompleter{}
+}
+
+// LogLevelCompleter returns a Completer for the usual log levels:
+// "debug", "info", "warn" and "error".
+func LogLevelCompleter() Completer {
+	return SetCompleter([]string{"debug", "info", "warn", "error"})
+}
+
+// BoolCompleter returns a Completer for boolean values: "true",
+// "false", "yes" and "no".
+func BoolCompleter() Completer {
+	return SetCompleter([]string{"true", "false", "yes", "no"})
+}
+
+// OutputFormatCompleter returns a Completer for the usual output
+// formats: "json", "text" and "yaml".
+func OutputFormatCompleter() Completer {
+	return SetCompleter([]string{"json", "text", "yaml"})
+}
+
+type positionalHintCompleter []string
+
+func (c positionalHintCompleter) Complete(cl CommandLine) []string {
+	i := len(cl) - 1
+	if cl.CurrentWord() != "" || i < 0 || i >= len(c) {
+		return nil
+	}
+	return []string{Hint(c[i])}
+}
+
+// PositionalHintCompleter returns a Completer that offers a hint (see
+// Hint) describing the positional argument expected, such as
+// "<file>" or "<host>", when the word being completed is empty. The
+// nth hint describes the nth argument of the CommandLine; there are
+// no hints for arguments beyond the last. It is intended to be used
+// as the inner Completer of CompleterWithFlags, possibly chained
+// with one offering actual completions (see ChainCompleter).
+func PositionalHintCompleter(hints ...string) Completer {
+	return positionalHintCompleter(hints)
+}
+
+// commonHeaders lists the HTTP request headers offered by
+// HeaderCompleter.
+var commonHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Authorization",
+	"Cache-Control",
+	"Content-Length",
+	"Content-Type",
+	"Cookie",
+	"If-Match",
+	"If-Modified-Since",
+	"If-None-Match",
+	"Origin",
+	"Range",
+	"Referer",
+	"User-Agent",
+	"X-Forwarded-For",
+	"X-Request-Id",
+}
+
+type headerCompleter []string
+
+func (c headerCompleter) Complete(cl CommandLine) []string {
+	word := cl.CurrentWord()
+	if strings.Contains(word, ":") {
+		// The value is free-form.
+		return nil
+	}
+	// Header names are case-insensitive. The completions keep the
+	// case of the word, so that they still start with it, and are
+	// all lower case if it is.
+	lower := word != strings.ToUpper(word) && word == strings.ToLower(word)
+	var completions []string
+	for _, name := range c {
+		if len(name) < len(word) || !strings.EqualFold(name[:len(word)], word) {
+			continue
+		}
+		if lower {
+			name = strings.ToLower(name)
+		}
+		completions = append(completions, word+name[len(word):]+":")
+	}
+	if completions != nil {
+		Signal(NoSpace)
+	}
+	return completions
+}
+
+// HeaderCompleter returns a Completer for values of the form
+// `Name: value', such as the HTTP headers given to the -H flag of an
+// API client. It completes the name from a list of common HTTP
+// request headers, and any extra names given, followed by a colon;
+// the shell is asked not to add a space, so that the user can go on
+// to type the value. Names match regardless of case, so `content-t'
+// completes to `content-type:'. Once the word contains a colon,
+// there are no completions.
+func HeaderCompleter(extra ...string) Completer {
+	return headerCompleter(append(append([]string(nil), commonHeaders...), extra...))
+}
+
+// defaultSchemes are the schemes offered by URLSchemeCompleter if
+// none are given.
+var defaultSchemes = []string{"http", "https", "file", "s3"}
+
+type urlSchemeCompleter []string
+
+func (c urlSchemeCompleter) Complete(cl CommandLine) []string {
+	word := cl.CurrentWord()
+	if strings.Contains(word, "://") {
+		return nil
+	}
+	var completions []string
+	for _, scheme := range c {
+		if s := scheme + "://"; strings.HasPrefix(s, word) {
+			completions = append(completions, s)
+		}
+	}
+	if completions != nil {
+		Signal(NoSpace)
+	}
+	return completions
+}
+
+// URLSchemeCompleter returns a Completer for URLs that offers their
+// schemes, such as "https://", while the word being completed has
+// none; the shell is asked not to add a space, so that the user can
+// go on to type the rest of the URL. Once the word has a scheme,
+// there are no completions. If no schemes are given, "http",
+// "https", "file" and "s3" are offered.
+func URLSchemeCompleter(schemes ...string) Completer {
+	if len(schemes) == 0 {
+		schemes = defaultSchemes
+	}
+	return urlSchemeCompleter(schemes)
+}
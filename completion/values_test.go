@@ -0,0 +1,188 @@
+package completion
+
+import (
+	. "launchpad.net/gocheck"
+	"math"
+	"strconv"
+	"time"
+)
+
+type ValueSuite struct{}
+
+var _ = Suite(&ValueSuite{})
+
+func (s *ValueSuite) TestCompleteList(c *C) {
+	candidates := []string{"cache", "tls", "http2", "http3"}
+	testCases := []struct {
+		partial     string
+		completions []string
+	}{
+		{"", candidates},
+		{"t", []string{"tls"}},
+		{"cache,", []string{"cache,tls", "cache,http2", "cache,http3"}},
+		{"cache,t", []string{"cache,tls"}},
+		{"cache,tls,http", []string{"cache,tls,http2", "cache,tls,http3"}},
+		{"cache,tls,http2,http3,", nil},
+		{"bogus,", []string{"bogus,cache", "bogus,tls", "bogus,http2", "bogus,http3"}},
+		{"x", nil},
+	}
+	for _, tc := range testCases {
+		c.Check(CompleteList(candidates, tc.partial, ","), DeepEquals, tc.completions,
+			Commentf("partial: %q", tc.partial))
+	}
+	c.Check(CompleteList(candidates, "tls::ca", "::"), DeepEquals, []string{"tls::cache"})
+}
+
+func (s *ValueSuite) TestCSVSetCompleter(c *C) {
+	completer := CSVSetCompleter([]string{"cache", "tls", "http2"})
+
+	resetDirectives()
+	c.Check(completer.Complete(CommandLine{"--features", "cache,t"}), DeepEquals, []string{"cache,tls"})
+	c.Check(currentDirectives(), Equals, NoSpace)
+
+	resetDirectives()
+	c.Check(completer.Complete(CommandLine{"cache,tls,http2,"}), IsNil)
+	c.Check(currentDirectives(), Equals, Directive(0))
+}
+
+func (s *ValueSuite) TestRangeCompleter(c *C) {
+	testCases := []struct {
+		min, max    int
+		word        string
+		completions []string
+		noSpace     bool
+	}{
+		{0, 5, "", []string{"0", "1", "2", "3", "4", "5"}, false},
+		{0, 5, "3", []string{"3"}, false},
+		{0, 5, "7", []string{}, false},
+		{0, 5, "x", []string{}, false},
+		{0, 5, "03", []string{}, false},
+		{1, 65535, "", []string{"1", "2", "3", "4", "5", "6", "7", "8", "9"}, false},
+		{1, 65535, "0", []string{}, false},
+		{1, 65535, "655", []string{"655", "6550", "6551", "6552", "6553", "6554", "6555", "6556", "6557", "6558", "6559"}, false},
+		{1, 65535, "6553", []string{"6553", "65530", "65531", "65532", "65533", "65534", "65535"}, false},
+		{10, 20, "", []string{"1", "2"}, true},
+		{10, 20, "1", []string{"10", "11", "12", "13", "14", "15", "16", "17", "18", "19"}, false},
+		{-3, 3, "", []string{"-", "0", "1", "2", "3"}, true},
+		{-3, 3, "-", []string{"-1", "-2", "-3"}, false},
+		{-3, 3, "-0", []string{}, false},
+		{-20, -10, "-", []string{"-1", "-2"}, true},
+		{-20, -10, "-1", []string{"-10", "-11", "-12", "-13", "-14", "-15", "-16", "-17", "-18", "-19"}, false},
+	}
+	for _, tc := range testCases {
+		resetDirectives()
+		completions := RangeCompleter(tc.min, tc.max).Complete(CommandLine{tc.word})
+		comment := Commentf("range [%d, %d], word %q", tc.min, tc.max, tc.word)
+		c.Check(completions, DeepEquals, tc.completions, comment)
+		c.Check(currentDirectives()&NoSpace != 0, Equals, tc.noSpace, comment)
+		c.Check(currentDirectives()&NoFileCompletion, Equals, NoFileCompletion, comment)
+	}
+}
+
+func (s *ValueSuite) TestNumRangeCompleter(c *C) {
+	testCases := []struct {
+		min, max, step int
+		word           string
+		completions    []string
+	}{
+		{10, 100, 10, "", []string{"10", "20", "30", "40", "50", "60", "70", "80", "90", "100"}},
+		{10, 100, 10, "1", []string{"10", "100"}},
+		{10, 100, 10, "5", []string{"50"}},
+		{10, 100, 10, "15", nil},
+		{0, 10, 3, "", []string{"0", "3", "6", "9"}},
+		{-10, 10, 5, "-", []string{"-10", "-5"}},
+		{5, 5, 2, "", []string{"5"}},
+		{5, 4, 1, "", nil},
+		{1, 9, 0, "", []string{"1"}},
+		{1, 9, -2, "", []string{"1"}},
+		{math.MaxInt - 3, math.MaxInt, 2, "", []string{strconv.Itoa(math.MaxInt - 3), strconv.Itoa(math.MaxInt - 1)}},
+	}
+	for _, tc := range testCases {
+		resetDirectives()
+		completions := NumRangeCompleter(tc.min, tc.max, tc.step).Complete(CommandLine{tc.word})
+		comment := Commentf("range [%d, %d] step %d, word %q", tc.min, tc.max, tc.step, tc.word)
+		c.Check(completions, DeepEquals, tc.completions, comment)
+		c.Check(currentDirectives(), Equals, NoFileCompletion, comment)
+	}
+}
+
+func (s *ValueSuite) TestTimeCompleter(c *C) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2024, 1, 15, 23, 30, 0, 0, time.UTC) }
+
+	testCases := []struct {
+		word        string
+		completions []string
+	}{
+		{"", []string{"2024-01-15", "today", "yesterday", "1h", "7d"}},
+		{"2024-01", []string{"2024-01-15"}},
+		{"t", []string{"today"}},
+		{"7", []string{"7d"}},
+		{"x", []string{}},
+	}
+	for _, tc := range testCases {
+		resetDirectives()
+		c.Check(TimeCompleter().Complete(CommandLine{tc.word}), DeepEquals, tc.completions,
+			Commentf("word: %q", tc.word))
+		c.Check(currentDirectives(), Equals, NoFileCompletion)
+	}
+}
+
+func (s *ValueSuite) TestPresets(c *C) {
+	c.Check(LogLevelCompleter().Complete(CommandLine{""}), DeepEquals, []string{"debug", "info", "warn", "error"})
+	c.Check(LogLevelCompleter().Complete(CommandLine{"w"}), DeepEquals, []string{"warn"})
+	c.Check(BoolCompleter().Complete(CommandLine{""}), DeepEquals, []string{"true", "false", "yes", "no"})
+	c.Check(BoolCompleter().Complete(CommandLine{"n"}), DeepEquals, []string{"no"})
+	c.Check(OutputFormatCompleter().Complete(CommandLine{""}), DeepEquals, []string{"json", "text", "yaml"})
+	c.Check(OutputFormatCompleter().Complete(CommandLine{"y"}), DeepEquals, []string{"yaml"})
+	c.Check(OutputFormatCompleter().Complete(CommandLine{"xml"}), IsNil)
+}
+
+func (s *ValueSuite) TestPositionalHintCompleter(c *C) {
+	completer := PositionalHintCompleter("<src>", "<dst>")
+	c.Check(completer.Complete(CommandLine{""}), DeepEquals, []string{Hint("<src>")})
+	c.Check(completer.Complete(CommandLine{"a", ""}), DeepEquals, []string{Hint("<dst>")})
+	c.Check(completer.Complete(CommandLine{"a", "b", ""}), IsNil)
+	c.Check(completer.Complete(CommandLine{"a", "b"}), IsNil)
+
+	chained := ChainCompleter(completer, SetCompleter([]string{"x.txt"}))
+	words := chained.Complete(CommandLine{""})
+	c.Check(completionOutput(words, 0, Zsh, true), DeepEquals, []string{"0", "\t<src>", "x.txt"})
+	c.Check(completionOutput(words, 0, Fish, true), DeepEquals, []string{"0", "x.txt"})
+	c.Check(completionOutput(words, 0, Bash, false), DeepEquals, []string{"x.txt"})
+	c.Check(completionOutput(words, 0, Bash, true), DeepEquals, []string{"0", "x.txt"})
+}
+
+func (s *ValueSuite) TestHeaderCompleter(c *C) {
+	completer := HeaderCompleter("X-Api-Key")
+	resetDirectives()
+	c.Check(completer.Complete(CommandLine{"Content-"}), DeepEquals, []string{"Content-Length:", "Content-Type:"})
+	c.Check(currentDirectives(), Equals, NoSpace)
+	c.Check(completer.Complete(CommandLine{"X-A"}), DeepEquals, []string{"X-Api-Key:"})
+	c.Check(completer.Complete(CommandLine{"content-"}), DeepEquals, []string{"content-length:", "content-type:"})
+	c.Check(completer.Complete(CommandLine{"x-a"}), DeepEquals, []string{"x-api-key:"})
+	c.Check(completer.Complete(CommandLine{"ACC"}), DeepEquals, []string{"ACCept:", "ACCept-Encoding:", "ACCept-Language:"})
+	c.Check(len(completer.Complete(CommandLine{""})), Equals, len(commonHeaders)+1)
+
+	resetDirectives()
+	c.Check(completer.Complete(CommandLine{"Content-Type: app"}), IsNil)
+	c.Check(completer.Complete(CommandLine{"Bogus"}), IsNil)
+	c.Check(currentDirectives(), Equals, Directive(0))
+}
+
+func (s *ValueSuite) TestURLSchemeCompleter(c *C) {
+	completer := URLSchemeCompleter()
+	resetDirectives()
+	c.Check(completer.Complete(CommandLine{""}), DeepEquals,
+		[]string{"http://", "https://", "file://", "s3://"})
+	c.Check(currentDirectives(), Equals, NoSpace)
+	c.Check(completer.Complete(CommandLine{"http"}), DeepEquals, []string{"http://", "https://"})
+	c.Check(completer.Complete(CommandLine{"https:"}), DeepEquals, []string{"https://"})
+
+	resetDirectives()
+	c.Check(completer.Complete(CommandLine{"https://example.com"}), IsNil)
+	c.Check(completer.Complete(CommandLine{"ftp"}), IsNil)
+	c.Check(currentDirectives(), Equals, Directive(0))
+
+	c.Check(URLSchemeCompleter("gs", "s3").Complete(CommandLine{""}), DeepEquals, []string{"gs://", "s3://"})
+}
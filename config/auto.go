@@ -0,0 +1,85 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A ParseFunc parses a config file in some format into a FlagSet,
+// like ParseConfigNamed does for the native format.
+type ParseFunc func(flags *flag.FlagSet, name string, r io.Reader) error
+
+// Native is the name of the native config file format, described in
+// the package documentation.
+const Native = "native"
+
+// extensionFormats maps file extensions to the names of the formats
+// conventionally using them.
+var extensionFormats = map[string]string{
+	".json": "json",
+	".toml": "toml",
+	".conf": Native,
+	".cfg":  Native,
+	".ini":  Native,
+}
+
+var formats = struct {
+	sync.Mutex
+	parsers map[string]ParseFunc
+}{parsers: map[string]ParseFunc{Native: ParseConfigNamed}}
+
+// RegisterFormat registers the parser for a config file format, for
+// use by LoadAuto. The formats detected by LoadAuto are "json",
+// "toml" and Native; only Native has a parser by default.
+func RegisterFormat(name string, parse ParseFunc) {
+	formats.Lock()
+	defer formats.Unlock()
+	formats.parsers[name] = parse
+}
+
+// DetectFormat determines the format of a config file from its
+// path's extension or, failing that, its contents: a file starting
+// with `{' is taken to be JSON. Anything else is taken to be in the
+// native format.
+func DetectFormat(path string, data []byte) string {
+	if format, ok := extensionFormats[strings.ToLower(filepath.Ext(path))]; ok {
+		return format
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return "json"
+	}
+	return Native
+}
+
+// LoadAuto loads a config file whose format is determined by
+// DetectFormat, using the parser registered for the format with
+// RegisterFormat. The path "-" denotes the standard input, whose
+// format is determined from its contents.
+func LoadAuto(flags *flag.FlagSet, path string) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		path = stdinName
+		data, err = ioutil.ReadAll(stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	format := DetectFormat(path, data)
+	formats.Lock()
+	parse := formats.parsers[format]
+	formats.Unlock()
+	if parse == nil {
+		return fmt.Errorf("%s: no parser registered for %s format", path, format)
+	}
+	return parse(flags, path, bytes.NewReader(data))
+}
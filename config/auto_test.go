@@ -0,0 +1,63 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"path/filepath"
+)
+
+type AutoSuite struct{}
+
+var _ = Suite(&AutoSuite{})
+
+func (s *AutoSuite) TestDetectFormat(c *C) {
+	testCases := []struct {
+		path   string
+		data   string
+		format string
+	}{
+		{"app.json", "", "json"},
+		{"app.TOML", "a = 1\n", "toml"},
+		{"app.conf", "{\n", Native},
+		{"app.ini", "[section]\n", Native},
+		{"app", "  \n{\"a\": 1}\n", "json"},
+		{"app", "\xef\xbb\xbf{}", "json"},
+		{"app", "[section]\nkey = value\n", Native},
+		{"app.yaml", "key = value\n", Native},
+		{"app", "", Native},
+	}
+	for _, tc := range testCases {
+		c.Check(DetectFormat(tc.path, []byte(tc.data)), Equals, tc.format,
+			Commentf("path %q, data %q", tc.path, tc.data))
+	}
+}
+
+func (s *AutoSuite) TestLoadAuto(c *C) {
+	flags := flag.NewFlagSet("auto", flag.ContinueOnError)
+	a := flags.String("a", "", "")
+	dir := c.MkDir()
+	native := filepath.Join(dir, "app.conf")
+	json := filepath.Join(dir, "app.json")
+	writeFile(c, native, "a = native\n")
+	writeFile(c, json, "{\"a\": \"json\"}\n")
+
+	c.Assert(LoadAuto(flags, native), IsNil)
+	c.Check(*a, Equals, "native")
+
+	err := LoadAuto(flags, json)
+	c.Check(err, ErrorMatches, ".*app.json: no parser registered for json format")
+
+	var seen string
+	RegisterFormat("json", func(flags *flag.FlagSet, name string, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		seen = string(data)
+		return err
+	})
+	defer RegisterFormat("json", nil)
+	c.Check(LoadAuto(flags, json), IsNil)
+	c.Check(seen, Equals, "{\"a\": \"json\"}\n")
+
+	c.Check(LoadAuto(flags, filepath.Join(dir, "missing.conf")), ErrorMatches, ".*no such file.*")
+}
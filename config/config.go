@@ -14,22 +14,103 @@
 //  FlagSet. For many applications, you can just pass in
 //  flag.CommandLine to expose your application's default set of
 //  command-line options as configuration frobs.
+//
+// A config file may include other config files with a line of the
+// form
+//
+//   include <path>
+//
+// Relative paths are interpreted relative to the directory of the
+// including file. The path may be a glob pattern, in which case every
+// matching file is included in lexical order; a pattern matching no
+// files is ignored. Otherwise, it is an error for the file not to
+// exist, unless it is included with
+//
+//   include? <path>
+//
+// which is useful for a machine-local override that may not exist.
+//
+// A value spanning several lines may be given as a here-document:
+//
+//   <key> <<EOF
+//   first line
+//   second line
+//   EOF
+//
+// The lines up to the one consisting of the delimiter (here, `EOF')
+// are taken verbatim and joined with newlines.
+//
+// A later config file can reset an option set by an earlier one to
+// its default value with a line of the form
+//
+//   unset <key>
+//
+// Keys may be grouped into sections, introduced by a line of the form
+//
+//   [section]
+//
+// Within a section, the key `key' refers to the flag named
+// `section.key'.
+//
+// With Parser.Quoted, a value may be written as a double-quoted Go
+// string literal, as in
+//
+//   greeting = "  hello,\tworld\n"
+//
+// to give it leading or trailing whitespace, or characters such as
+// newlines. Escape sequences are only interpreted in quoted values;
+// backslashes elsewhere are taken literally. A quoted value may be
+// followed by a comment, separated by whitespace:
+//
+//   greeting = "hello # world"  # the first `#' is part of the value
+//
+// A `#' in an unquoted value has no special meaning. By default,
+// quotes are not special either, and a value such as
+// `"C:\temp\new"' is taken as written, quotes and backslashes
+// included.
+//
+// A `=' in a key, such as in a key addressing part of a PathSetter,
+// must be escaped with a backslash, as in `\='. Values may contain
+// `=' freely.
 package config
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 )
 
+// maxIncludeDepth bounds the nesting of include directives, to catch
+// include cycles.
+const maxIncludeDepth = 32
+
 // LoadConfig loads configuration from a dotfile. It looks for
-// $HOME/.basename, and, if it exists, opens it and calls
-// ParseConfig. Returns silently if no such file exists.
+// .basename in the user's home directory (as determined by
+// os.UserHomeDir, so $HOME on Unix and %USERPROFILE% on Windows),
+// and, if it exists, opens it and calls ParseConfig. Returns silently
+// if no such file exists, but fails if there is no home directory to
+// look in. As a special case, a basename of "-" reads the config from
+// the standard input.
 func LoadConfig(flags *flag.FlagSet, basename string) error {
-	path := os.ExpandEnv(fmt.Sprintf("${HOME}/.%s", basename))
+	if basename == "-" {
+		return ParseConfigNamed(flags, stdinName, stdin)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("cannot locate config file .%s: %v", basename, err)
+	}
+	path := filepath.Join(home, "."+basename)
 	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -38,34 +119,837 @@ func LoadConfig(flags *flag.FlagSet, basename string) error {
 		return err
 	}
 	defer f.Close()
-	return ParseConfig(flags, f)
+	return ParseConfigNamed(flags, path, f)
 }
 
 // ParseConfig parses a config file, using the provided FlagSet to
 // look up, parse, and store values.
 func ParseConfig(flags *flag.FlagSet, f io.Reader) error {
+	return new(Parser).Parse(flags, f)
+}
+
+// ParseConfigTemplate is like ParseConfig, but treats each value as a
+// template, executed with data. See Parser.TemplateData.
+func ParseConfigTemplate(flags *flag.FlagSet, f io.Reader, data map[string]interface{}) error {
+	return (&Parser{TemplateData: data}).Parse(flags, f)
+}
+
+// ParseConfigNamed is like ParseConfig, but takes the name of the
+// file being parsed. Errors are annotated with the name and line
+// number, and relative include paths are resolved against the
+// file's directory.
+func ParseConfigNamed(flags *flag.FlagSet, name string, f io.Reader) error {
+	return new(Parser).ParseNamed(flags, name, f)
+}
+
+// A Parser parses config files, with options controlling what it
+// accepts. The zero Parser behaves like ParseConfig.
+type Parser struct {
+	// Strict enables checks for likely mistakes in a config
+	// file: a key that is set more than once in the same file,
+	// or a section header that appears more than once. Flags
+	// whose value has an IsRepeatable method returning true,
+	// such as flags accumulating a list, may be set repeatedly.
+	Strict bool
+
+	// IndexedKeys enables keys that address part of a flag
+	// holding structured data, such as `server.0.host'. If no
+	// flag is named by such a key, it is split before its first
+	// numeric component; if the part before names a flag whose
+	// value is a PathSetter, its SetPath method is called with
+	// the remaining components and the value.
+	IndexedKeys bool
+
+	// Transformers maps keys to functions that transform their
+	// values before they are set, such as to normalize their
+	// case. Keys in sections are given by their full names
+	// (`section.key').
+	Transformers map[string]func(string) string
+
+	// Validators maps keys to functions that check their values,
+	// after any transformation, before they are set. They allow a
+	// flag declared as a plain string, but meant to hold JSON,
+	// say, to have its value rejected with an error that names
+	// the option and its line. Keys in sections are given by their
+	// full names, as for Transformers.
+	Validators map[string]func(value string) error
+
+	// Interpolate enables references to other keys in values:
+	// `${key}' is replaced by the value of key, which must be set
+	// in the same file, before or after the reference. Keys in
+	// sections are referred to by their full names. If a key is
+	// set more than once, its last value is used. All references
+	// in a file are resolved before any of its values are set;
+	// references that form a cycle are reported as errors.
+	//
+	// `${flag:name}' is replaced by the current value of the flag
+	// name, as its String method returns it, at the time the line
+	// containing the reference is applied. Unlike references to
+	// keys, this depends on order: the value reflects only the
+	// lines above the reference, the files included before it,
+	// and whatever set the flag before the config was parsed, such
+	// as its default. A reference to a flag that is not defined
+	// is an error. The name is that of the flag, including any
+	// Prefix.
+	Interpolate bool
+
+	// BareBools allows boolean flags to be enabled by a line
+	// consisting of just the key, as in ssh_config and many INI
+	// dialects: `verbose' on its own is equivalent to
+	// `verbose = true'. A bare key naming a non-boolean flag is
+	// an error.
+	BareBools bool
+
+	// Required lists keys that must be set by the config file,
+	// or by the files it includes. If any are not, Parse fails
+	// with an error listing all of the missing keys. A key reset
+	// by an unset directive counts as not set.
+	Required []string
+
+	// SkipEmpty changes the meaning of a key given an empty value,
+	// as in `key ='. By default, the flag is set to the empty
+	// string, which suits string flags that may legitimately be
+	// empty. With SkipEmpty, the line is ignored, leaving the flag
+	// with its previous value, so that a template config can list
+	// keys without overriding their defaults.
+	SkipEmpty bool
+
+	// Prefix, if not empty, is prepended to every key, separated
+	// by a `.', to find the flag it sets. It allows a config file
+	// written for a library's flags to be loaded into a program
+	// that embeds the library under a prefix: with the prefix
+	// "cache", the key `ttl' sets the flag `cache.ttl'. The names
+	// in Transformers, Validators and Required are flag names,
+	// including the prefix.
+	Prefix string
+
+	// TemplateData, if not nil, causes each value to be executed
+	// as a text/template template, with TemplateData as its data,
+	// before it is set: `greeting = Hello {{.User}}' sets the flag
+	// to "Hello " followed by TemplateData["User"]. A template
+	// referring to a missing key is an error. Values are templates
+	// only when this is set, since values may contain `{{'
+	// literally.
+	TemplateData map[string]interface{}
+
+	// NegateBools allows a boolean flag to be disabled by setting
+	// its name prefixed with `no_': `no_verbose = true' is
+	// equivalent to `verbose = false'. Within a section, the
+	// prefix goes on the key, as in `[log] no_verbose = true'. If
+	// a file sets a flag both ways, the last line wins, and Warn
+	// is called.
+	NegateBools bool
+
+	// Quoted enables double-quoted values, which are unquoted as
+	// Go string literals and may be followed by a comment (see
+	// the package documentation). It is off by default, so that
+	// values that happen to start with a quote, such as Windows
+	// paths, are taken literally. Files written by WriteConfig
+	// must be read with Quoted set.
+	Quoted bool
+
+	// Warn, if not nil, is called with warnings about likely
+	// mistakes that are not errors, annotated with the file name
+	// and line number.
+	Warn func(err error)
+
+	// AllowExec enables values that run a command: a value of the
+	// form `$(command)', as in `password = $(pass show db)', is
+	// replaced by the output of running command with /bin/sh,
+	// with surrounding whitespace removed. Only a whole value is
+	// run; a quoted value (see Quoted) is always taken literally.
+	// A command that fails, or that doesn't finish within
+	// ExecTimeout, is an error. Commands are run before references
+	// are interpolated (see Interpolate), so a reference to a key
+	// set by a command gets its output without running it again;
+	// references within a command are not expanded, and neither a
+	// command's output nor an interpolated value is ever run.
+	// Since a config file can then run arbitrary commands as the
+	// user, it must be enabled only for files as trusted as the
+	// program itself.
+	AllowExec bool
+
+	// ExecTimeout bounds how long a command run for AllowExec may
+	// take. A zero ExecTimeout means DefaultExecTimeout.
+	ExecTimeout time.Duration
+
+	// fsys, if not nil, is the file system from which included
+	// files are read, instead of the operating system's. See
+	// ParseFS.
+	fsys fs.FS
+}
+
+// A PathSetter is a flag.Value that can be set using structured
+// keys. See Parser.IndexedKeys.
+type PathSetter interface {
+	flag.Value
+	// SetPath sets the part of the value identified by path, a
+	// key split at `.', starting with a numeric index. For
+	// example, the key `server.0.host' sets the flag `server'
+	// with the path ["0", "host"].
+	SetPath(path []string, value string) error
+}
+
+// A repeatableFlag is a flag.Value that is meant to be set more than
+// once.
+type repeatableFlag interface {
+	flag.Value
+	IsRepeatable() bool
+}
+
+func isRepeatable(f *flag.Flag) bool {
+	rf, ok := f.Value.(repeatableFlag)
+	return ok && rf.IsRepeatable()
+}
+
+// boolFlag is implemented by flag.Values for boolean flags, which
+// may be given without a value on the command line.
+type boolFlag interface {
+	flag.Value
+	IsBoolFlag() bool
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	bf, ok := f.Value.(boolFlag)
+	return ok && bf.IsBoolFlag()
+}
+
+// Parse parses a config file, using the provided FlagSet to look up,
+// parse, and store values.
+func (p *Parser) Parse(flags *flag.FlagSet, f io.Reader) error {
+	return p.ParseNamed(flags, "", f)
+}
+
+// ParseNamed is like Parse, but takes the name of the file being
+// parsed, as for ParseConfigNamed.
+func (p *Parser) ParseNamed(flags *flag.FlagSet, name string, f io.Reader) error {
+	set := make(map[string]bool)
+	if err := p.parse(flags, name, f, 0, set); err != nil {
+		return err
+	}
+	return p.checkRequired(name, set)
+}
+
+// parse parses a config file, recording the keys that are set in
+// set. Keys reset by an unset directive are recorded as false.
+func (p *Parser) parse(flags *flag.FlagSet, name string, f io.Reader, depth int, set map[string]bool) error {
+	entries, lineno, err := p.parseEntries(f)
+	if err != nil {
+		return lineError(name, lineno, err)
+	}
+	if p.Strict {
+		if err := p.checkDuplicates(flags, name, entries); err != nil {
+			return err
+		}
+	}
+	if p.AllowExec {
+		if err := p.runCommands(name, entries); err != nil {
+			return err
+		}
+	}
+	if p.Interpolate {
+		if err := interpolate(name, entries); err != nil {
+			return err
+		}
+	}
+	if p.NegateBools && p.Warn != nil {
+		p.checkNegations(flags, name, entries)
+	}
+	for _, e := range entries {
+		if err := p.apply(flags, name, e, depth, set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDuplicates checks for keys and sections that appear more than
+// once in a file.
+func (p *Parser) checkDuplicates(flags *flag.FlagSet, name string, entries []Entry) error {
+	keys := make(map[string]int)
+	sections := make(map[string]int)
+	for _, e := range entries {
+		switch {
+		case e.Directive == "section":
+			if first, ok := sections[e.Section]; ok {
+				return lineError(name, e.Line,
+					fmt.Errorf("section `[%s]' repeated (first on line %d)", e.Section, first))
+			}
+			sections[e.Section] = e.Line
+		case e.Key != "":
+			key := e.fullKey()
+			if f := flags.Lookup(p.flagName(key)); f != nil && isRepeatable(f) {
+				continue
+			}
+			if first, ok := keys[key]; ok {
+				return lineError(name, e.Line,
+					fmt.Errorf("option `%s' set on line %d and again on line %d", key, first, e.Line))
+			}
+			keys[key] = e.Line
+		}
+	}
+	return nil
+}
+
+func (p *Parser) apply(flags *flag.FlagSet, name string, e Entry, depth int, set map[string]bool) error {
+	switch e.Directive {
+	case "", "section":
+	case "include", "include?":
+		if depth >= maxIncludeDepth {
+			return lineError(name, e.Line, fmt.Errorf("includes nested too deeply"))
+		}
+		return p.include(flags, name, e.Value, e.Directive == "include?", depth, set)
+	case "unset":
+		return p.unset(flags, name, e, set)
+	default:
+		return lineError(name, e.Line, fmt.Errorf("unknown directive `%s'", e.Directive))
+	}
+	if e.Key == "" || (p.SkipEmpty && e.Value == "" && !e.bare) {
+		return nil
+	}
+
+	key := p.flagName(e.fullKey())
+	value := e.Value
+	// The output of a command is used as is.
+	if p.Interpolate && !e.ran {
+		var err error
+		if value, err = expandFlags(flags, value); err != nil {
+			return lineError(name, e.Line, err)
+		}
+	}
+	if p.TemplateData != nil && !e.ran {
+		var err error
+		if value, err = executeTemplate(value, p.TemplateData); err != nil {
+			err = fmt.Errorf("option `%s': %v", key, err)
+			if name == "" {
+				// The template alone doesn't say where it
+				// came from, so give the line regardless.
+				return fmt.Errorf("line %d: %v", e.Line, err)
+			}
+			return lineError(name, e.Line, err)
+		}
+	}
+	if transform := p.Transformers[key]; transform != nil {
+		value = transform(value)
+	}
+	if validate := p.Validators[key]; validate != nil {
+		if err := validate(value); err != nil {
+			return lineError(name, e.Line, fmt.Errorf("invalid value `%s' for option `%s': %v", value, key, err))
+		}
+	}
+	flag := flags.Lookup(key)
+	if flag == nil && p.NegateBools {
+		if f := negatedBool(flags, key); f != nil {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return lineError(name, e.Line, fmt.Errorf("invalid boolean value `%s' for option `%s'", value, key))
+			}
+			flag, key, value = f, f.Name, strconv.FormatBool(!b)
+		}
+	}
+	if flag == nil {
+		if p.IndexedKeys && !e.bare {
+			if ps, path := lookupPath(flags, key); ps != nil {
+				if err := ps.SetPath(path, value); err != nil {
+					return lineError(name, e.Line, err)
+				}
+				set[key] = true
+				return nil
+			}
+		}
+		return lineError(name, e.Line, unknownOption(flags, key))
+	}
+	if e.bare && !isBoolFlag(flag) {
+		return lineError(name, e.Line, fmt.Errorf("option `%s' requires a value", key))
+	}
+
+	if err := setFlag(flags, flag, value); err != nil {
+		return lineError(name, e.Line, err)
+	}
+	set[key] = true
+	return nil
+}
+
+// setFlag sets f to value. If the value doesn't parse, the error
+// names the type of value the flag expects, rather than repeating
+// the flag package's terse "parse error". Any other error, such as
+// one from a custom flag.Value, is kept and annotated with the
+// option's name.
+func setFlag(flags *flag.FlagSet, f *flag.Flag, value string) error {
+	err := flags.Set(f.Name, value)
+	if err == nil {
+		return nil
+	}
+	if expected := expectedType(f); expected != "" {
+		switch err.Error() {
+		case errRange.Error():
+			return fmt.Errorf("value `%s' out of range for option `%s'", value, f.Name)
+		case errParse.Error():
+			return fmt.Errorf("invalid value `%s' for option `%s': expected %s", value, f.Name, expected)
+		}
+	}
+	return fmt.Errorf("invalid value `%s' for option `%s': %v", value, f.Name, err)
+}
+
+// expectedType describes the values f accepts, or returns "" if f
+// isn't one of the basic types, in which case its own errors are
+// left alone.
+func expectedType(f *flag.Flag) string {
+	g, ok := f.Value.(flag.Getter)
+	if !ok {
+		return ""
+	}
+	v := g.Get()
+	if _, ok := v.(time.Duration); ok {
+		return "a duration, such as 1h30m"
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	}
+	return ""
+}
+
+// executeTemplate executes a value as a template with the given data.
+func executeTemplate(value string, data map[string]interface{}) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+	tmpl, err := template.New("value").Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// negatedPrefix is the prefix of a key disabling a boolean flag. See
+// Parser.NegateBools.
+const negatedPrefix = "no_"
+
+// negatedBool returns the boolean flag disabled by a key of the form
+// `no_name', or `section.no_name' for a flag in a section, or nil if
+// there is none.
+func negatedBool(flags *flag.FlagSet, key string) *flag.Flag {
+	i := strings.LastIndex(key, ".") + 1
+	if !strings.HasPrefix(key[i:], negatedPrefix) {
+		return nil
+	}
+	f := flags.Lookup(key[:i] + key[i+len(negatedPrefix):])
+	if f == nil || !isBoolFlag(f) {
+		return nil
+	}
+	return f
+}
+
+// checkNegations warns of boolean flags that a file sets both
+// directly and by their negated keys.
+func (p *Parser) checkNegations(flags *flag.FlagSet, name string, entries []Entry) {
+	type setting struct {
+		key  string
+		line int
+	}
+	last := make(map[string]setting)
+	for _, e := range entries {
+		if e.Key == "" {
+			continue
+		}
+		key := p.flagName(e.fullKey())
+		target := key
+		if flags.Lookup(key) == nil {
+			f := negatedBool(flags, key)
+			if f == nil {
+				continue
+			}
+			target = f.Name
+		}
+		if prev, ok := last[target]; ok && prev.key != key {
+			p.Warn(lineError(name, e.Line,
+				fmt.Errorf("option `%s' overrides `%s' set on line %d", key, prev.key, prev.line)))
+		}
+		last[target] = setting{key, e.Line}
+	}
+}
+
+// unknownOption returns the error for a key naming no flag. A key
+// containing whitespace is most likely a typo, such as a missing `='
+// or a space in place of a `-' or `_', so the error suggests what
+// may have been meant.
+func unknownOption(flags *flag.FlagSet, key string) error {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return fmt.Errorf("unknown option `%s'", key)
+	}
+	for _, sep := range []string{"-", "_", ""} {
+		if joined := strings.Join(fields, sep); flags.Lookup(joined) != nil {
+			return fmt.Errorf("unknown option `%s' (did you mean `%s'?)", key, joined)
+		}
+	}
+	if flags.Lookup(fields[0]) != nil {
+		return fmt.Errorf("unknown option `%s' (missing `=' after `%s'?)", key, fields[0])
+	}
+	return fmt.Errorf("unknown option `%s' (keys can't contain spaces)", key)
+}
+
+// unset resets the flag named by an unset directive to its default
+// value.
+func (p *Parser) unset(flags *flag.FlagSet, name string, e Entry, set map[string]bool) error {
+	key := e.Value
+	if e.Section != "" {
+		key = e.Section + "." + key
+	}
+	key = p.flagName(key)
+	f := flags.Lookup(key)
+	if f == nil {
+		return lineError(name, e.Line, fmt.Errorf("unknown option `%s'", key))
+	}
+	if err := flags.Set(key, f.DefValue); err != nil {
+		return lineError(name, e.Line, err)
+	}
+	set[key] = false
+	return nil
+}
+
+// flagName returns the name of the flag set by a key, already
+// qualified by its section, by adding p.Prefix.
+func (p *Parser) flagName(key string) string {
+	if p.Prefix == "" {
+		return key
+	}
+	return p.Prefix + "." + key
+}
+
+// checkRequired checks that the keys in p.Required were set.
+func (p *Parser) checkRequired(name string, set map[string]bool) error {
+	var missing []string
+	for _, key := range p.Required {
+		if !set[key] {
+			missing = append(missing, "`"+key+"'")
+		}
+	}
+	if missing == nil {
+		return nil
+	}
+	err := fmt.Errorf("missing required options: %s", strings.Join(missing, ", "))
+	if name != "" {
+		err = fmt.Errorf("%s: %v", name, err)
+	}
+	return err
+}
+
+// lookupPath splits an indexed key before its first numeric
+// component, and looks up a PathSetter flag named by the part before
+// it.
+func lookupPath(flags *flag.FlagSet, key string) (PathSetter, []string) {
+	parts := strings.Split(key, ".")
+	for i := 1; i < len(parts); i++ {
+		if !isIndex(parts[i]) {
+			continue
+		}
+		f := flags.Lookup(strings.Join(parts[:i], "."))
+		if f == nil {
+			return nil, nil
+		}
+		ps, ok := f.Value.(PathSetter)
+		if !ok {
+			return nil, nil
+		}
+		return ps, parts[i:]
+	}
+	return nil, nil
+}
+
+func isIndex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// An Entry is a single line of a config file, as returned by
+// ParseEntries. Blank lines and comments are represented by entries
+// with an empty Key and Directive.
+type Entry struct {
+	// Line is the line number of the entry, starting at 1.
+	Line int
+	// Raw is the text of the line, exactly as it appears in the
+	// file.
+	Raw string
+	// Key and Value are the key and value of a `key = value'
+	// line, with surrounding whitespace removed and, with
+	// Parser.Quoted, a quoted value unquoted. For a here-document,
+	// Raw and Value span several lines.
+	Key   string
+	Value string
+	// Comment is the text of a comment line, without the leading
+	// `#'.
+	Comment string
+	// Directive is the name of the directive on a directive line,
+	// such as "include", "include?" or "unset". The directive's
+	// argument is stored in Value. Section headers are represented as a
+	// "section" directive.
+	Directive string
+	// Section is the name of the section in which the entry
+	// appears, or empty if it precedes any section header.
+	Section string
+
+	// bare is set for a key given without a value. See
+	// Parser.BareBools.
+	bare bool
+	// quoted is set for a value given in double quotes. See
+	// Parser.Quoted and Parser.AllowExec.
+	quoted bool
+	// ran is set for a value replaced by the output of a command.
+	// See Parser.AllowExec.
+	ran bool
+}
+
+// fullKey returns the name of the flag set by a key/value entry,
+// qualified by its section.
+func (e Entry) fullKey() string {
+	if e.Section == "" {
+		return e.Key
+	}
+	return e.Section + "." + e.Key
+}
+
+// ParseEntries parses a config file into a list of entries, one per
+// line, without looking up or setting any flags. This allows
+// programs to inspect or edit config files without applying them.
+func ParseEntries(f io.Reader) ([]Entry, error) {
+	entries, lineno, err := new(Parser).parseEntries(f)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", lineno, err)
+	}
+	return entries, nil
+}
+
+// parseEntries does the work of ParseEntries. On error, it also
+// returns the line number at which the error occurred.
+func (p *Parser) parseEntries(f io.Reader) ([]Entry, int, error) {
+	var entries []Entry
+	var section string
 	scanner := bufio.NewScanner(f)
+	lineno := 0
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+		lineno++
+		e := Entry{Line: lineno, Raw: scanner.Text()}
+		line := strings.TrimSpace(e.Raw)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			e.Comment = strings.TrimSpace(line[1:])
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, lineno, fmt.Errorf("illegal section header: `%s'", line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" {
+				return nil, lineno, fmt.Errorf("illegal section header: `%s'", line)
+			}
+			e.Directive = "section"
+			e.Value = section
+		default:
+			if pattern, ok := directive(line, "include?"); ok {
+				e.Directive = "include?"
+				e.Value = pattern
+				break
+			}
+			if pattern, ok := directive(line, "include"); ok {
+				e.Directive = "include"
+				e.Value = pattern
+				break
+			}
+			if key, ok := directive(line, "unset"); ok {
+				e.Directive = "unset"
+				e.Value = key
+				break
+			}
+
+			if key, delim, ok := hereDoc(line); ok {
+				start := lineno
+				var body []string
+				terminated := false
+				for scanner.Scan() {
+					lineno++
+					e.Raw += "\n" + scanner.Text()
+					if strings.TrimSpace(scanner.Text()) == delim {
+						terminated = true
+						break
+					}
+					body = append(body, scanner.Text())
+				}
+				if !terminated {
+					if err := scanner.Err(); err != nil {
+						return nil, lineno, err
+					}
+					return nil, lineno, fmt.Errorf("unterminated here-document `%s' starting on line %d", delim, start)
+				}
+				e.Key = key
+				e.Value = strings.Join(body, "\n")
+				break
+			}
+
+			key, value, ok := splitAssignment(line)
+			if !ok && p.BareBools && !strings.ContainsAny(line, " \t") {
+				e.Key = line
+				e.Value = "true"
+				e.bare = true
+				break
+			}
+			if !ok {
+				return nil, lineno, fmt.Errorf("illegal config line: `%s'", line)
+			}
+
+			e.Key = strings.TrimSpace(key)
+			e.Value = strings.TrimSpace(value)
+			if p.Quoted && strings.HasPrefix(e.Value, `"`) {
+				unquoted, err := unquoteValue(e.Value)
+				if err != nil {
+					return nil, lineno, err
+				}
+				e.Value = unquoted
+				e.quoted = true
+			}
 		}
+		e.Section = section
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, lineno, err
+	}
+	return entries, lineno, nil
+}
+
+// splitAssignment splits a `key = value' line at the first `=' not
+// escaped with a backslash. Escaped `='s in the key are unescaped, so
+// that keys may contain them.
+func splitAssignment(line string) (key, value string, ok bool) {
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '=':
+			i++
+		case line[i] == '=':
+			return strings.Replace(line[:i], `\=`, "=", -1), line[i+1:], true
+		}
+	}
+	return "", "", false
+}
 
-		bits := strings.SplitN(line, "=", 2)
-		if len(bits) != 2 {
-			return fmt.Errorf("illegal config line: `%s'", line)
+// unquoteValue unquotes a value starting with a double quote. The
+// closing quote may be followed by whitespace and a comment.
+func unquoteValue(value string) (string, error) {
+	end := -1
+	for i := 1; i < len(value) && end < 0; i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			end = i
+		}
+	}
+	if end > 0 {
+		rest := value[end+1:]
+		trimmed := strings.TrimSpace(rest)
+		if trimmed == "" || (trimmed[0] == '#' && len(trimmed) < len(rest)) {
+			if unquoted, err := strconv.Unquote(value[:end+1]); err == nil {
+				return unquoted, nil
+			}
 		}
+	}
+	return "", fmt.Errorf("malformed quoted value: `%s'", value)
+}
+
+// hereDoc checks whether a config line starts a here-document, of
+// the form `key <<DELIM', and if so returns the key and delimiter.
+func hereDoc(line string) (key, delim string, ok bool) {
+	i := strings.Index(line, "<<")
+	if i < 0 || strings.Contains(line[:i], "=") {
+		return "", "", false
+	}
+	key = strings.TrimSpace(line[:i])
+	delim = strings.TrimSpace(line[i+2:])
+	if key == "" || delim == "" || strings.ContainsAny(delim, " \t") {
+		return "", "", false
+	}
+	return key, delim, true
+}
 
-		key := strings.TrimSpace(bits[0])
-		value := strings.TrimSpace(bits[1])
+// directive checks whether a config line invokes the named
+// directive, and if so returns the directive's argument.
+func directive(line, name string) (string, bool) {
+	if !strings.HasPrefix(line, name) {
+		return "", false
+	}
+	rest := line[len(name):]
+	if rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+		return "", false
+	}
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, "=") {
+		// This is an assignment to a key that happens to share
+		// the directive's name.
+		return "", false
+	}
+	return rest, true
+}
 
-		if flag := flags.Lookup(key); flag == nil {
-			return fmt.Errorf("unknown option `%s'", key)
+// include parses the files named by an include directive in the
+// config file from. If optional is set, a missing file is skipped.
+func (p *Parser) include(flags *flag.FlagSet, from, pattern string, optional bool, depth int, set map[string]bool) error {
+	pattern = p.resolvePath(from, pattern)
+	paths := []string{pattern}
+	if hasGlobMeta(pattern) {
+		var err error
+		if paths, err = p.glob(pattern); err != nil {
+			return err
 		}
+		sort.Strings(paths)
+	}
 
-		if err := flags.Set(key, value); err != nil {
+	for _, path := range paths {
+		if err := p.includeFile(flags, path, optional, depth, set); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+func (p *Parser) includeFile(flags *flag.FlagSet, path string, optional bool, depth int, set map[string]bool) error {
+	f, err := p.open(path)
+	if err != nil {
+		if optional && os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	return p.parse(flags, path, f, depth+1, set)
+}
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// lineError annotates err with a file name and line number, if the
+// file has a name.
+func lineError(name string, lineno int, err error) error {
+	if name == "" {
+		return err
+	}
+	return fmt.Errorf("%s:%d: %v", name, lineno, err)
+}
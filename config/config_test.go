@@ -1,10 +1,17 @@
 package config
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test(t *testing.T) { TestingT(t) }
@@ -41,6 +48,26 @@ func (s *ConfigSuite) TestNoSuchFlag(c *C) {
 	c.Assert(err.Error(), Equals, "unknown option `notaflag'")
 }
 
+func (s *ConfigSuite) TestSpaceInKey(c *C) {
+	s.flags.String("log-level", "", "")
+	s.flags.String("max_count", "", "")
+	s.flags.String("url", "", "")
+	testCases := []struct {
+		line string
+		err  string
+	}{
+		{"log level = debug", "unknown option `log level' \\(did you mean `log-level'\\?\\)"},
+		{"max count = 3", "unknown option `max count' \\(did you mean `max_count'\\?\\)"},
+		{"str ing = x", "unknown option `str ing' \\(did you mean `string'\\?\\)"},
+		{"url http://host/?q=1", "unknown option `url http://host/\\?q' \\(missing `=' after `url'\\?\\)"},
+		{"my key = x", "unknown option `my key' \\(keys can't contain spaces\\)"},
+	}
+	for _, tc := range testCases {
+		err := ParseConfig(s.flags, strings.NewReader(tc.line+"\n"))
+		c.Check(err, ErrorMatches, tc.err, Commentf("line: %s", tc.line))
+	}
+}
+
 func (s *ConfigSuite) TestInvalidLine(c *C) {
 	err := ParseConfig(s.flags, strings.NewReader(""+
 		"foo \n"))
@@ -64,3 +91,715 @@ func (s *ConfigSuite) TestWhitespace(c *C) {
 	c.Assert(*s.intFlag, Equals, 128)
 	c.Assert(*s.strFlag, Equals, "value#with spaces")
 }
+
+func writeFile(c *C, path, contents string) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(path, []byte(contents), 0644)
+	c.Assert(err, IsNil)
+}
+
+func (s *ConfigSuite) TestInclude(c *C) {
+	dir := c.MkDir()
+	writeFile(c, filepath.Join(dir, "main.conf"), ""+
+		"int = 1\n"+
+		"include conf.d/*.conf\n"+
+		"include none.d/*.conf\n")
+	writeFile(c, filepath.Join(dir, "conf.d", "20-b.conf"), ""+
+		"int = 20\n"+
+		"string = b\n")
+	writeFile(c, filepath.Join(dir, "conf.d", "10-a.conf"), ""+
+		"int = 10\n"+
+		"string = a\n"+
+		"include ../extra.conf\n")
+	writeFile(c, filepath.Join(dir, "extra.conf"), ""+
+		"int = 15\n")
+
+	path := filepath.Join(dir, "main.conf")
+	f, err := os.Open(path)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	err = ParseConfigNamed(s.flags, path, f)
+	c.Assert(err, IsNil)
+	c.Assert(*s.intFlag, Equals, 20)
+	c.Assert(*s.strFlag, Equals, "b")
+}
+
+func (s *ConfigSuite) TestIncludeMissing(c *C) {
+	dir := c.MkDir()
+	err := ParseConfigNamed(s.flags, filepath.Join(dir, "main.conf"), strings.NewReader(""+
+		"include missing.conf\n"))
+	c.Assert(err, NotNil)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *ConfigSuite) TestOptionalInclude(c *C) {
+	dir := c.MkDir()
+	main := filepath.Join(dir, "main.conf")
+	err := ParseConfigNamed(s.flags, main, strings.NewReader(""+
+		"int = 1\n"+
+		"include? local.conf\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.intFlag, Equals, 1)
+
+	writeFile(c, filepath.Join(dir, "local.conf"), ""+
+		"int = 2\n"+
+		"include missing.conf\n")
+	err = ParseConfigNamed(s.flags, main, strings.NewReader(""+
+		"include? local.conf\n"))
+	c.Assert(err, NotNil)
+	c.Check(os.IsNotExist(err), Equals, true)
+	c.Check(*s.intFlag, Equals, 2)
+
+	entries, err := ParseEntries(strings.NewReader("include? local.conf\n"))
+	c.Assert(err, IsNil)
+	c.Check(entries[0].Directive, Equals, "include?")
+	c.Check(entries[0].Value, Equals, "local.conf")
+}
+
+func (s *ConfigSuite) TestIncludeErrorLocation(c *C) {
+	dir := c.MkDir()
+	writeFile(c, filepath.Join(dir, "bad.conf"), ""+
+		"# a comment\n"+
+		"notaflag = 1\n")
+	err := ParseConfigNamed(s.flags, filepath.Join(dir, "main.conf"), strings.NewReader(""+
+		"include bad.conf\n"))
+	c.Assert(err, ErrorMatches, ".*bad.conf:2: unknown option `notaflag'")
+}
+
+func (s *ConfigSuite) TestIncludeCycle(c *C) {
+	dir := c.MkDir()
+	writeFile(c, filepath.Join(dir, "loop.conf"), "include loop.conf\n")
+	err := ParseConfigNamed(s.flags, filepath.Join(dir, "main.conf"), strings.NewReader(""+
+		"include loop.conf\n"))
+	c.Assert(err, ErrorMatches, ".*nested too deeply")
+}
+
+func (s *ConfigSuite) TestIncludeKey(c *C) {
+	s.flags.String("include", "", "A flag named like a directive")
+	err := ParseConfig(s.flags, strings.NewReader(""+
+		"include = value\n"))
+	c.Assert(err, IsNil)
+	c.Assert(s.flags.Lookup("include").Value.String(), Equals, "value")
+}
+
+func (s *ConfigSuite) TestParseEntries(c *C) {
+	entries, err := ParseEntries(strings.NewReader("" +
+		"# this is a comment\n" +
+		"  int = 17\n" +
+		"\n" +
+		"include other.conf\n" +
+		"notaflag = hello world\n"))
+	c.Assert(err, IsNil)
+	c.Assert(entries, DeepEquals, []Entry{
+		{Line: 1, Raw: "# this is a comment", Comment: "this is a comment"},
+		{Line: 2, Raw: "  int = 17", Key: "int", Value: "17"},
+		{Line: 3, Raw: ""},
+		{Line: 4, Raw: "include other.conf", Directive: "include", Value: "other.conf"},
+		{Line: 5, Raw: "notaflag = hello world", Key: "notaflag", Value: "hello world"},
+	})
+	c.Assert(*s.intFlag, Equals, 0)
+}
+
+func (s *ConfigSuite) TestParseEntriesInvalidLine(c *C) {
+	_, err := ParseEntries(strings.NewReader("" +
+		"int = 1\n" +
+		"foo\n"))
+	c.Assert(err, ErrorMatches, "line 2: illegal config line: `foo'")
+}
+
+type listValue []string
+
+func (l *listValue) String() string     { return strings.Join(*l, ",") }
+func (l *listValue) Set(v string) error { *l = append(*l, v); return nil }
+func (l *listValue) IsRepeatable() bool { return true }
+
+func (s *ConfigSuite) TestSections(c *C) {
+	host := s.flags.String("server.host", "", "")
+	port := s.flags.Int("server.port", 0, "")
+	err := ParseConfig(s.flags, strings.NewReader(""+
+		"int = 1\n"+
+		"[server]\n"+
+		"host = example.com\n"+
+		"  [ server ]  \n"+
+		"port = 80\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.intFlag, Equals, 1)
+	c.Check(*host, Equals, "example.com")
+	c.Check(*port, Equals, 80)
+
+	err = ParseConfig(s.flags, strings.NewReader(""+
+		"[server]\n"+
+		"int = 1\n"))
+	c.Check(err, ErrorMatches, "unknown option `server.int'")
+
+	err = ParseConfig(s.flags, strings.NewReader("[server\n"))
+	c.Check(err, ErrorMatches, "illegal section header: .*")
+	err = ParseConfig(s.flags, strings.NewReader("[]\n"))
+	c.Check(err, ErrorMatches, "illegal section header: .*")
+}
+
+func (s *ConfigSuite) TestStrictDuplicates(c *C) {
+	var list listValue
+	s.flags.Var(&list, "list", "A repeatable flag")
+	s.flags.String("a.b", "", "")
+
+	p := &Parser{Strict: true}
+	err := p.Parse(s.flags, strings.NewReader(""+
+		"int = 1\n"+
+		"string = x\n"+
+		"int = 2\n"))
+	c.Check(err, ErrorMatches, "option `int' set on line 1 and again on line 3")
+	c.Check(*s.intFlag, Equals, 0)
+
+	err = p.ParseNamed(s.flags, "test.conf", strings.NewReader(""+
+		"[a]\n"+
+		"b = 1\n"+
+		"[c]\n"+
+		"[a]\n"))
+	c.Check(err, ErrorMatches, "test.conf:4: section `\\[a\\]' repeated \\(first on line 1\\)")
+
+	err = p.Parse(s.flags, strings.NewReader(""+
+		"a.b = 1\n"+
+		"[a]\n"+
+		"b = 2\n"))
+	c.Check(err, ErrorMatches, "option `a.b' set on line 1 and again on line 3")
+
+	err = p.Parse(s.flags, strings.NewReader(""+
+		"list = 1\n"+
+		"list = 2\n"))
+	c.Check(err, IsNil)
+	c.Check([]string(list), DeepEquals, []string{"1", "2"})
+
+	err = ParseConfig(s.flags, strings.NewReader(""+
+		"int = 1\n"+
+		"int = 2\n"))
+	c.Check(err, IsNil)
+	c.Check(*s.intFlag, Equals, 2)
+}
+
+type server struct {
+	host string
+	port string
+}
+
+type serversValue []server
+
+func (v *serversValue) String() string     { return fmt.Sprint(*v) }
+func (v *serversValue) Set(s string) error { return fmt.Errorf("use indexed keys") }
+func (v *serversValue) SetPath(path []string, value string) error {
+	if len(path) != 2 {
+		return fmt.Errorf("bad server key: %v", path)
+	}
+	i, err := strconv.Atoi(path[0])
+	if err != nil {
+		return err
+	}
+	for len(*v) <= i {
+		*v = append(*v, server{})
+	}
+	switch path[1] {
+	case "host":
+		(*v)[i].host = value
+	case "port":
+		(*v)[i].port = value
+	default:
+		return fmt.Errorf("unknown server field `%s'", path[1])
+	}
+	return nil
+}
+
+func (s *ConfigSuite) TestIndexedKeys(c *C) {
+	var servers serversValue
+	s.flags.Var(&servers, "server", "")
+	s.flags.Var(&servers, "cluster.server", "")
+	s.flags.String("plain.0", "", "")
+
+	p := &Parser{IndexedKeys: true}
+	err := p.Parse(s.flags, strings.NewReader(""+
+		"server.0.host = a.example.com\n"+
+		"server.1.host = b.example.com\n"+
+		"server.0.port = 8080\n"+
+		"plain.0 = literal\n"+
+		"[cluster]\n"+
+		"server.1.port = 9090\n"))
+	c.Assert(err, IsNil)
+	c.Check([]server(servers), DeepEquals, []server{
+		{"a.example.com", "8080"},
+		{"b.example.com", "9090"},
+	})
+	c.Check(s.flags.Lookup("plain.0").Value.String(), Equals, "literal")
+
+	err = p.Parse(s.flags, strings.NewReader("server.0.user = x\n"))
+	c.Check(err, ErrorMatches, "unknown server field `user'")
+	err = p.Parse(s.flags, strings.NewReader("int.0 = 1\n"))
+	c.Check(err, ErrorMatches, "unknown option `int.0'")
+	err = p.Parse(s.flags, strings.NewReader("server.host = x\n"))
+	c.Check(err, ErrorMatches, "unknown option `server.host'")
+
+	err = ParseConfig(s.flags, strings.NewReader("server.0.host = x\n"))
+	c.Check(err, ErrorMatches, "unknown option `server.0.host'")
+}
+
+func (s *ConfigSuite) TestTransformers(c *C) {
+	host := s.flags.String("server.host", "", "")
+	p := &Parser{Transformers: map[string]func(string) string{
+		"string":      strings.ToUpper,
+		"server.host": strings.ToLower,
+	}}
+	err := p.Parse(s.flags, strings.NewReader(""+
+		"string = hello\n"+
+		"int = 3\n"+
+		"[server]\n"+
+		"host = Example.COM\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.strFlag, Equals, "HELLO")
+	c.Check(*s.intFlag, Equals, 3)
+	c.Check(*host, Equals, "example.com")
+}
+
+func (s *ConfigSuite) TestValidators(c *C) {
+	settings := s.flags.String("server.settings", "", "")
+	timeout := s.flags.String("timeout", "", "")
+	p := &Parser{
+		Quoted:       true,
+		Transformers: map[string]func(string) string{"timeout": strings.TrimSpace},
+		Validators: map[string]func(string) error{
+			"server.settings": func(value string) error {
+				var v interface{}
+				return json.Unmarshal([]byte(value), &v)
+			},
+			"timeout": func(value string) error {
+				_, err := time.ParseDuration(value)
+				return err
+			},
+		},
+	}
+	err := p.Parse(s.flags, strings.NewReader(""+
+		"timeout = 5s\n"+
+		"[server]\n"+
+		"settings = {\"debug\": true}\n"))
+	c.Assert(err, IsNil)
+	c.Check(*timeout, Equals, "5s")
+	c.Check(*settings, Equals, `{"debug": true}`)
+
+	err = p.ParseNamed(s.flags, "app.conf", strings.NewReader("string = x\ntimeout = \"  5 s \"\n"))
+	c.Check(err, ErrorMatches, "app.conf:2: invalid value `5 s' for option `timeout': time: unknown unit .*")
+	c.Check(*timeout, Equals, "5s")
+	err = p.Parse(s.flags, strings.NewReader("[server]\nsettings = {debug}\n"))
+	c.Check(err, ErrorMatches, "invalid value `{debug}' for option `server.settings': invalid character .*")
+}
+
+func (s *ConfigSuite) TestHereDoc(c *C) {
+	err := ParseConfig(s.flags, strings.NewReader(""+
+		"int = 1\n"+
+		"string <<END\n"+
+		"  Dear user,\n"+
+		"\n"+
+		"# not a comment; int = 2\n"+
+		"END\n"+
+		"int = 3\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.strFlag, Equals, "  Dear user,\n\n# not a comment; int = 2")
+	c.Check(*s.intFlag, Equals, 3)
+
+	entries, err := ParseEntries(strings.NewReader("" +
+		"string <<EOF\n" +
+		"a\n" +
+		"EOF\n" +
+		"int = 1\n"))
+	c.Assert(err, IsNil)
+	c.Check(entries, DeepEquals, []Entry{
+		{Line: 1, Raw: "string <<EOF\na\nEOF", Key: "string", Value: "a"},
+		{Line: 4, Raw: "int = 1", Key: "int", Value: "1"},
+	})
+
+	err = ParseConfig(s.flags, strings.NewReader("string <<EOF\nEOF\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.strFlag, Equals, "")
+
+	err = ParseConfigNamed(s.flags, "test.conf", strings.NewReader(""+
+		"int = 1\n"+
+		"string <<EOF\n"+
+		"no end\n"))
+	c.Check(err, ErrorMatches, "test.conf:3: unterminated here-document `EOF' starting on line 2")
+
+	err = ParseConfig(s.flags, strings.NewReader("string = a <<b\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.strFlag, Equals, "a <<b")
+}
+
+func (s *ConfigSuite) TestUnset(c *C) {
+	host := s.flags.String("server.host", "localhost", "")
+	err := ParseConfig(s.flags, strings.NewReader(""+
+		"int = 3\n"+
+		"string = system\n"+
+		"server.host = example.com\n"))
+	c.Assert(err, IsNil)
+
+	err = ParseConfig(s.flags, strings.NewReader(""+
+		"unset string\n"+
+		"[server]\n"+
+		"unset host\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.intFlag, Equals, 3)
+	c.Check(*s.strFlag, Equals, "STRING")
+	c.Check(*host, Equals, "localhost")
+
+	err = ParseConfigNamed(s.flags, "user.conf", strings.NewReader(""+
+		"int = 4\n"+
+		"unset bogus\n"))
+	c.Check(err, ErrorMatches, "user.conf:2: unknown option `bogus'")
+
+	entries, err := ParseEntries(strings.NewReader("unset int\n"))
+	c.Assert(err, IsNil)
+	c.Check(entries, DeepEquals, []Entry{
+		{Line: 1, Raw: "unset int", Directive: "unset", Value: "int"},
+	})
+
+	s.flags.String("unset", "", "A flag named like a directive")
+	err = ParseConfig(s.flags, strings.NewReader("unset = value\n"))
+	c.Assert(err, IsNil)
+	c.Check(s.flags.Lookup("unset").Value.String(), Equals, "value")
+}
+
+// pathsValue records the paths and values it is set with.
+type pathsValue map[string]string
+
+func (v pathsValue) String() string     { return fmt.Sprint(map[string]string(v)) }
+func (v pathsValue) Set(s string) error { return fmt.Errorf("use indexed keys") }
+func (v pathsValue) SetPath(path []string, value string) error {
+	v[strings.Join(path, ".")] = value
+	return nil
+}
+
+func (s *ConfigSuite) TestEscapedEquals(c *C) {
+	defines := make(pathsValue)
+	s.flags.Var(defines, "define", "")
+	p := &Parser{IndexedKeys: true}
+	err := p.Parse(s.flags, strings.NewReader(""+
+		"define.0.x\\=1 = heavy=yes\n"+
+		"string = http://x?a=1&b=2\n"))
+	c.Assert(err, IsNil)
+	c.Check(defines, DeepEquals, pathsValue{"0.x=1": "heavy=yes"})
+	c.Check(*s.strFlag, Equals, "http://x?a=1&b=2")
+
+	entries, err := ParseEntries(strings.NewReader("a\\=b\\=c = d\n"))
+	c.Assert(err, IsNil)
+	c.Check(entries[0].Key, Equals, "a=b=c")
+	c.Check(entries[0].Value, Equals, "d")
+
+	err = ParseConfig(s.flags, strings.NewReader("a\\=b = c\n"))
+	c.Check(err, ErrorMatches, "unknown option `a=b'")
+	err = ParseConfig(s.flags, strings.NewReader("a\\=b\n"))
+	c.Check(err, ErrorMatches, "illegal config line: .*")
+}
+
+func (s *ConfigSuite) TestBareBools(c *C) {
+	verbose := s.flags.Bool("verbose", false, "")
+	color := s.flags.Bool("ui.color", false, "")
+	p := &Parser{BareBools: true}
+	err := p.Parse(s.flags, strings.NewReader(""+
+		"verbose\n"+
+		"int = 2\n"+
+		"[ui]\n"+
+		"  color  \n"))
+	c.Assert(err, IsNil)
+	c.Check(*verbose, Equals, true)
+	c.Check(*color, Equals, true)
+	c.Check(*s.intFlag, Equals, 2)
+
+	err = p.ParseNamed(s.flags, "test.conf", strings.NewReader("int\n"))
+	c.Check(err, ErrorMatches, "test.conf:1: option `int' requires a value")
+	err = p.Parse(s.flags, strings.NewReader("bogus\n"))
+	c.Check(err, ErrorMatches, "unknown option `bogus'")
+	err = p.Parse(s.flags, strings.NewReader("verbose yes\n"))
+	c.Check(err, ErrorMatches, "illegal config line: `verbose yes'")
+
+	*verbose = false
+	err = ParseConfig(s.flags, strings.NewReader("verbose\n"))
+	c.Check(err, ErrorMatches, "illegal config line: `verbose'")
+	c.Check(*verbose, Equals, false)
+}
+
+func (s *ConfigSuite) TestRequired(c *C) {
+	dir := c.MkDir()
+	writeFile(c, filepath.Join(dir, "extra.conf"), "server.host = example.com\n")
+	s.flags.String("server.host", "", "")
+	s.flags.String("server.port", "", "")
+	c.Assert(s.flags.Set("server.port", "80"), IsNil)
+
+	p := &Parser{Required: []string{"int", "server.host"}}
+	err := p.ParseNamed(s.flags, filepath.Join(dir, "main.conf"), strings.NewReader(""+
+		"int = 1\n"+
+		"include extra.conf\n"))
+	c.Check(err, IsNil)
+
+	p.Required = []string{"int", "string", "server.host", "server.port"}
+	err = p.ParseNamed(s.flags, "main.conf", strings.NewReader(""+
+		"[server]\n"+
+		"host = example.com\n"))
+	c.Check(err, ErrorMatches, "main.conf: missing required options: `int', `string', `server.port'")
+
+	err = p.Parse(s.flags, strings.NewReader(""+
+		"int = 1\n"+
+		"string = x\n"+
+		"server.host = h\n"+
+		"server.port = 8080\n"+
+		"unset string\n"))
+	c.Check(err, ErrorMatches, "missing required options: `string'")
+}
+
+func (s *ConfigSuite) TestSkipEmpty(c *C) {
+	config := "" +
+		"string =\n" +
+		"int = 3\n"
+	err := ParseConfig(s.flags, strings.NewReader(config))
+	c.Assert(err, IsNil)
+	c.Check(*s.strFlag, Equals, "")
+
+	*s.strFlag = "STRING"
+	p := &Parser{SkipEmpty: true}
+	err = p.Parse(s.flags, strings.NewReader(config+"int = \n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.strFlag, Equals, "STRING")
+	c.Check(*s.intFlag, Equals, 3)
+
+	p.Required = []string{"string"}
+	err = p.Parse(s.flags, strings.NewReader(config))
+	c.Check(err, ErrorMatches, "missing required options: `string'")
+}
+
+func (s *ConfigSuite) TestBackslashes(c *C) {
+	testCases := []struct {
+		line  string
+		value string
+	}{
+		{`string = C:\temp\x`, `C:\temp\x`},
+		{`string = C:\temp\`, `C:\temp\`},
+		{`string = \\server\share\ `, `\\server\share\`},
+		{`string = C:\new\table`, `C:\new\table`},
+		{`string = a\=b`, `a\=b`},
+		{`string = \${x}`, `\${x}`},
+		{`string = "C:\temp\new"`, `"C:\temp\new"`},
+		{`string = "C:\Program Files\x"`, `"C:\Program Files\x"`},
+		{`string = "hi" there`, `"hi" there`},
+	}
+	for _, tc := range testCases {
+		for _, p := range []*Parser{{}, {Interpolate: true, SkipEmpty: true}} {
+			if p.Interpolate && strings.Contains(tc.line, "${") {
+				continue
+			}
+			err := p.Parse(s.flags, strings.NewReader(tc.line+"\n"))
+			c.Assert(err, IsNil, Commentf("line: %s", tc.line))
+			c.Check(*s.strFlag, Equals, tc.value, Commentf("line: %s", tc.line))
+		}
+	}
+
+	err := ParseConfig(s.flags, strings.NewReader(""+
+		"string <<EOF\n"+
+		`C:\temp\`+"\n"+
+		"EOF\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.strFlag, Equals, `C:\temp\`)
+}
+
+func (s *ConfigSuite) TestQuotedValues(c *C) {
+	testCases := []struct {
+		line  string
+		value string
+	}{
+		{`string = "  padded  "`, "  padded  "},
+		{`string = "a\tb\n"`, "a\tb\n"},
+		{`string = "# not a comment"`, "# not a comment"},
+		{`string = ""`, ""},
+		{`string = say "hi"`, `say "hi"`},
+		{`string = "value" # comment`, "value"},
+		{"string = \"value\"\t#comment", "value"},
+		{`string = "val # ue"`, "val # ue"},
+		{`string = "val # ue"  # "comment"`, "val # ue"},
+		{`string = "say \"hi\" # there" # comment`, `say "hi" # there`},
+		{`string = "ends in \\" # comment`, `ends in \`},
+		{`string = value # not a comment`, "value # not a comment"},
+	}
+	p := &Parser{Quoted: true}
+	for _, tc := range testCases {
+		err := p.Parse(s.flags, strings.NewReader(tc.line+"\n"))
+		c.Assert(err, IsNil, Commentf("line: %s", tc.line))
+		c.Check(*s.strFlag, Equals, tc.value, Commentf("line: %s", tc.line))
+	}
+
+	err := p.Parse(s.flags, strings.NewReader(`string = "unterminated`+"\n"))
+	c.Check(err, ErrorMatches, "malformed quoted value: `\"unterminated'")
+	for _, value := range []string{`"value"# comment`, `"value" trailing`, `"a\qb"`, `"escaped\"`} {
+		err := p.Parse(s.flags, strings.NewReader("string = "+value+"\n"))
+		c.Check(err, ErrorMatches, "malformed quoted value: .*", Commentf("value: %s", value))
+	}
+}
+
+func (s *ConfigSuite) TestTemplate(c *C) {
+	data := map[string]interface{}{"User": "ada", "Port": 8080}
+	err := ParseConfigTemplate(s.flags, strings.NewReader(""+
+		"string = Hello {{.User}}\n"+
+		"int = {{.Port}}\n"), data)
+	c.Assert(err, IsNil)
+	c.Check(*s.strFlag, Equals, "Hello ada")
+	c.Check(*s.intFlag, Equals, 8080)
+
+	err = ParseConfig(s.flags, strings.NewReader("string = Hello {{.User}}\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.strFlag, Equals, "Hello {{.User}}")
+
+	p := &Parser{TemplateData: data}
+	err = p.ParseNamed(s.flags, "app.conf", strings.NewReader(""+
+		"int = 1\n"+
+		"string = {{.Missing}}\n"))
+	c.Check(err, ErrorMatches, "app.conf:2: option `string': .*map has no entry for key \"Missing\"")
+	err = p.ParseNamed(s.flags, "app.conf", strings.NewReader("string = {{.User\n"))
+	c.Check(err, ErrorMatches, "app.conf:1: option `string': template: .*")
+
+	err = ParseConfigTemplate(s.flags, strings.NewReader(""+
+		"int = 1\n"+
+		"string = {{.Missing}}\n"), data)
+	c.Check(err, ErrorMatches, "line 2: option `string': .*map has no entry for key \"Missing\"")
+}
+
+func (s *ConfigSuite) TestNegateBools(c *C) {
+	verbose := s.flags.Bool("verbose", true, "")
+	color := s.flags.Bool("log.color", true, "")
+	var warnings []string
+	p := &Parser{
+		NegateBools: true,
+		BareBools:   true,
+		Warn:        func(err error) { warnings = append(warnings, err.Error()) },
+	}
+
+	err := p.ParseNamed(s.flags, "app.conf", strings.NewReader(""+
+		"no_verbose = true\n"+
+		"[log]\n"+
+		"no_color\n"))
+	c.Assert(err, IsNil)
+	c.Check(*verbose, Equals, false)
+	c.Check(*color, Equals, false)
+	c.Check(warnings, IsNil)
+
+	err = p.ParseNamed(s.flags, "app.conf", strings.NewReader("no_verbose = false\n"))
+	c.Assert(err, IsNil)
+	c.Check(*verbose, Equals, true)
+
+	err = p.ParseNamed(s.flags, "app.conf", strings.NewReader(""+
+		"verbose = true\n"+
+		"no_verbose = true\n"+
+		"log.color = false\n"))
+	c.Assert(err, IsNil)
+	c.Check(*verbose, Equals, false)
+	c.Check(warnings, DeepEquals, []string{"app.conf:2: option `no_verbose' overrides `verbose' set on line 1"})
+
+	err = p.ParseNamed(s.flags, "app.conf", strings.NewReader("no_verbose = maybe\n"))
+	c.Check(err, ErrorMatches, "app.conf:1: invalid boolean value `maybe' for option `no_verbose'")
+	err = p.ParseNamed(s.flags, "app.conf", strings.NewReader("no_string = true\n"))
+	c.Check(err, ErrorMatches, "app.conf:1: unknown option `no_string'")
+
+	err = ParseConfig(s.flags, strings.NewReader("no_verbose = true\n"))
+	c.Check(err, ErrorMatches, "unknown option `no_verbose'")
+}
+
+func (s *ConfigSuite) TestPrefix(c *C) {
+	ttl := s.flags.Int("cache.ttl", 0, "")
+	host := s.flags.String("cache.redis.host", "", "")
+	config := "" +
+		"ttl = 60\n" +
+		"[redis]\n" +
+		"host = localhost\n"
+
+	p := &Parser{Prefix: "cache", Strict: true, Required: []string{"cache.ttl"}}
+	c.Assert(p.Parse(s.flags, strings.NewReader(config)), IsNil)
+	c.Check(*ttl, Equals, 60)
+	c.Check(*host, Equals, "localhost")
+
+	c.Assert(p.Parse(s.flags, strings.NewReader("ttl = 60\nunset ttl\n")), ErrorMatches,
+		"missing required options: `cache.ttl'")
+	c.Check(*ttl, Equals, 0)
+
+	err := p.Parse(s.flags, strings.NewReader("int = 1\n"))
+	c.Check(err, ErrorMatches, "unknown option `cache.int'")
+
+	standalone := flag.NewFlagSet("lib", flag.ContinueOnError)
+	libTTL := standalone.Int("ttl", 0, "")
+	standalone.String("redis.host", "", "")
+	c.Assert(ParseConfig(standalone, strings.NewReader(config)), IsNil)
+	c.Check(*libTTL, Equals, 60)
+}
+
+type portValue int
+
+func (p *portValue) String() string   { return strconv.Itoa(int(*p)) }
+func (p *portValue) Get() interface{} { return int(*p) }
+func (p *portValue) Set(v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	if n > 65535 {
+		return fmt.Errorf("port %d must be at most 65535", n)
+	}
+	*p = portValue(n)
+	return nil
+}
+
+func (s *ConfigSuite) TestCustomFlagErrors(c *C) {
+	flags := flag.NewFlagSet("custom", flag.ContinueOnError)
+	flags.Var(new(portValue), "port", "")
+	err := ParseConfig(flags, strings.NewReader("port = 70000\n"))
+	c.Check(err, ErrorMatches, "invalid value `70000' for option `port': port 70000 must be at most 65535")
+	err = ParseConfig(flags, strings.NewReader("port = http\n"))
+	c.Check(err, ErrorMatches, "invalid value `http' for option `port': strconv.Atoi: parsing \"http\": invalid syntax")
+}
+
+func (s *ConfigSuite) TestFlagTypes(c *C) {
+	flags := flag.NewFlagSet("types", flag.ContinueOnError)
+	flags.Int("int", 0, "")
+	flags.Int64("int64", 0, "")
+	flags.Uint("uint", 0, "")
+	flags.Uint64("uint64", 0, "")
+	flags.Float64("float64", 0, "")
+	flags.Duration("duration", 0, "")
+	flags.Bool("bool", false, "")
+
+	testCases := []struct {
+		key, value string
+		want       string
+		err        string
+	}{
+		{"int", "-17", "-17", ""},
+		{"int", "0x10", "16", ""},
+		{"int", "1.5", "", "invalid value `1.5' for option `int': expected an integer"},
+		{"int", "seventeen", "", "invalid value `seventeen' for option `int': expected an integer"},
+		{"int64", "-9223372036854775808", "-9223372036854775808", ""},
+		{"int64", "9223372036854775808", "", "value `9223372036854775808' out of range for option `int64'"},
+		{"int64", "1e3", "", "invalid value `1e3' for option `int64': expected an integer"},
+		{"uint", "17", "17", ""},
+		{"uint", "-1", "", "invalid value `-1' for option `uint': expected a non-negative integer"},
+		{"uint64", "18446744073709551615", "18446744073709551615", ""},
+		{"uint64", "18446744073709551616", "", "value `18446744073709551616' out of range for option `uint64'"},
+		{"float64", "2.5", "2.5", ""},
+		{"float64", "-1e3", "-1000", ""},
+		{"float64", "two", "", "invalid value `two' for option `float64': expected a number"},
+		{"duration", "1h30m", "1h30m0s", ""},
+		{"duration", "90", "", "invalid value `90' for option `duration': expected a duration, such as 1h30m"},
+		{"bool", "true", "true", ""},
+		{"bool", "0", "false", ""},
+		{"bool", "yes", "", "invalid value `yes' for option `bool': expected a boolean"},
+	}
+	for _, tc := range testCases {
+		err := ParseConfig(flags, strings.NewReader(tc.key+" = "+tc.value+"\n"))
+		if tc.err != "" {
+			c.Check(err, ErrorMatches, tc.err, Commentf("%s = %s", tc.key, tc.value))
+			continue
+		}
+		if c.Check(err, IsNil, Commentf("%s = %s", tc.key, tc.value)) {
+			c.Check(flags.Lookup(tc.key).Value.String(), Equals, tc.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultExecTimeout is the time allowed for a command run for
+// Parser.AllowExec, if Parser.ExecTimeout is zero.
+const DefaultExecTimeout = 10 * time.Second
+
+// execCommand returns the command in a value of the form
+// `$(command)'.
+func execCommand(value string) (string, bool) {
+	if !strings.HasPrefix(value, "$(") || !strings.HasSuffix(value, ")") {
+		return "", false
+	}
+	return value[2 : len(value)-1], true
+}
+
+// runCommand runs command with the shell and returns its output,
+// with surrounding whitespace removed.
+func (p *Parser) runCommand(command string) (string, error) {
+	timeout := p.ExecTimeout
+	if timeout == 0 {
+		timeout = DefaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// Don't wait for the command's own children, which may hold
+	// its output open, once it has been killed.
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("command `%s' timed out after %v", command, timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("command `%s' failed: %v: %s", command, err, msg)
+		}
+		return "", fmt.Errorf("command `%s' failed: %v", command, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// runCommands replaces the value of each entry of the form
+// `$(command)' with the output of the command, in place.
+func (p *Parser) runCommands(name string, entries []Entry) error {
+	for i, e := range entries {
+		command, ok := execCommand(e.Value)
+		if e.Key == "" || e.quoted || !ok {
+			continue
+		}
+		output, err := p.runCommand(command)
+		if err != nil {
+			return lineError(name, e.Line, fmt.Errorf("option `%s': %v", p.flagName(e.fullKey()), err))
+		}
+		entries[i].Value = output
+		entries[i].ran = true
+	}
+	return nil
+}
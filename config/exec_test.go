@@ -0,0 +1,83 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type ExecSuite struct {
+	flags    *flag.FlagSet
+	password *string
+}
+
+var _ = Suite(&ExecSuite{})
+
+func (s *ExecSuite) SetUpTest(c *C) {
+	s.flags = flag.NewFlagSet("exec", flag.ContinueOnError)
+	s.password = s.flags.String("password", "", "")
+}
+
+func (s *ExecSuite) TestAllowExec(c *C) {
+	p := &Parser{AllowExec: true}
+	err := p.Parse(s.flags, strings.NewReader("password = $(echo '  s3cret ' | tr s S)\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.password, Equals, "S3cret")
+
+	p.Quoted = true
+	err = p.Parse(s.flags, strings.NewReader(`password = "$(echo quoted)"`+"\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.password, Equals, "$(echo quoted)")
+
+	err = p.Parse(s.flags, strings.NewReader("password = x$(echo partial)\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.password, Equals, "x$(echo partial)")
+
+	err = ParseConfig(s.flags, strings.NewReader("password = $(echo disabled)\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.password, Equals, "$(echo disabled)")
+}
+
+func (s *ExecSuite) TestAllowExecOutputIsLiteral(c *C) {
+	p := &Parser{AllowExec: true, Interpolate: true, TemplateData: map[string]interface{}{}}
+	err := p.Parse(s.flags, strings.NewReader("password = $(printf '%s' '${flag:password}{{.X}}')\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.password, Equals, "${flag:password}{{.X}}")
+}
+
+func (s *ExecSuite) TestAllowExecInterpolate(c *C) {
+	user := s.flags.String("user", "", "")
+	count := filepath.Join(c.MkDir(), "count")
+	p := &Parser{AllowExec: true, Interpolate: true, Quoted: true}
+	config := "password = $(echo run >> " + count + "; echo s3cret)\n" +
+		"user = ${password}\n"
+	err := p.Parse(s.flags, strings.NewReader(config))
+	c.Assert(err, IsNil)
+	c.Check(*s.password, Equals, "s3cret")
+	c.Check(*user, Equals, "s3cret")
+	runs, err := ioutil.ReadFile(count)
+	c.Assert(err, IsNil)
+	c.Check(string(runs), Equals, "run\n")
+
+	// An interpolated value is never run.
+	err = p.Parse(s.flags, strings.NewReader(`password = "$(echo ran)"`+"\nuser = ${password}\n"))
+	c.Assert(err, IsNil)
+	c.Check(*user, Equals, "$(echo ran)")
+}
+
+func (s *ExecSuite) TestAllowExecErrors(c *C) {
+	p := &Parser{AllowExec: true}
+	err := p.ParseNamed(s.flags, "app.conf", strings.NewReader("\npassword = $(echo oops >&2; exit 3)\n"))
+	c.Check(err, ErrorMatches, "app.conf:2: option `password': command `echo oops >&2; exit 3' failed: exit status 3: oops")
+	err = p.ParseNamed(s.flags, "app.conf", strings.NewReader("password = $(exit 1)\n"))
+	c.Check(err, ErrorMatches, "app.conf:1: option `password': command `exit 1' failed: exit status 1")
+
+	p.ExecTimeout = 50 * time.Millisecond
+	start := time.Now()
+	err = p.ParseNamed(s.flags, "app.conf", strings.NewReader("password = $(sleep 5)\n"))
+	c.Check(err, ErrorMatches, "app.conf:1: option `password': command `sleep 5' timed out after 50ms")
+	c.Check(time.Since(start) < 3*time.Second, Equals, true)
+}
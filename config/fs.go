@@ -0,0 +1,69 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ParseConfigFS parses the config file name in the file system fsys,
+// such as one embedded in the program with go:embed. Relative
+// include paths are resolved within fsys. It is an error for the file
+// not to exist; see LoadConfigFS.
+func ParseConfigFS(flags *flag.FlagSet, fsys fs.FS, name string) error {
+	return new(Parser).ParseFS(flags, fsys, name)
+}
+
+// LoadConfigFS is like ParseConfigFS, but returns silently if the
+// file doesn't exist, as LoadConfig does.
+func LoadConfigFS(flags *flag.FlagSet, fsys fs.FS, name string) error {
+	if _, err := fs.Stat(fsys, name); os.IsNotExist(err) {
+		return nil
+	}
+	return ParseConfigFS(flags, fsys, name)
+}
+
+// ParseFS is like ParseConfigFS, but parses the file with the
+// options in p.
+func (p *Parser) ParseFS(flags *flag.FlagSet, fsys fs.FS, name string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	q := *p
+	q.fsys = fsys
+	return q.ParseNamed(flags, name, f)
+}
+
+// resolvePath resolves the path in an include directive in the config
+// file from.
+func (p *Parser) resolvePath(from, name string) string {
+	if p.fsys != nil {
+		if path.IsAbs(name) {
+			return name[1:]
+		}
+		return path.Join(path.Dir(from), name)
+	}
+	if !filepath.IsAbs(name) && from != "" {
+		return filepath.Join(filepath.Dir(from), name)
+	}
+	return name
+}
+
+func (p *Parser) glob(pattern string) ([]string, error) {
+	if p.fsys != nil {
+		return fs.Glob(p.fsys, pattern)
+	}
+	return filepath.Glob(pattern)
+}
+
+func (p *Parser) open(name string) (io.ReadCloser, error) {
+	if p.fsys != nil {
+		return p.fsys.Open(name)
+	}
+	return os.Open(name)
+}
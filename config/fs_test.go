@@ -0,0 +1,61 @@
+package config
+
+import (
+	"flag"
+	. "launchpad.net/gocheck"
+	"os"
+	"testing/fstest"
+)
+
+type FSSuite struct {
+	flags *flag.FlagSet
+	a     *string
+	b     *string
+	fsys  fstest.MapFS
+}
+
+var _ = Suite(&FSSuite{})
+
+func (s *FSSuite) SetUpTest(c *C) {
+	s.flags = flag.NewFlagSet("fs", flag.ContinueOnError)
+	s.a = s.flags.String("a", "flag", "")
+	s.b = s.flags.String("b", "flag", "")
+	s.fsys = fstest.MapFS{
+		"defaults/app.conf":      {Data: []byte("a = default\ninclude conf.d/*.conf\ninclude? local.conf\n")},
+		"defaults/conf.d/b.conf": {Data: []byte("b = included\n")},
+		"bad.conf":               {Data: []byte("bogus = 1\n")},
+		"broken.conf":            {Data: []byte("include missing.conf\n")},
+	}
+}
+
+func (s *FSSuite) TestParseConfigFS(c *C) {
+	c.Assert(ParseConfigFS(s.flags, s.fsys, "defaults/app.conf"), IsNil)
+	c.Check(*s.a, Equals, "default")
+	c.Check(*s.b, Equals, "included")
+
+	c.Check(ParseConfigFS(s.flags, s.fsys, "bad.conf"), ErrorMatches, "bad.conf:1: unknown option `bogus'")
+
+	err := ParseConfigFS(s.flags, s.fsys, "missing.conf")
+	c.Check(os.IsNotExist(err), Equals, true)
+	err = ParseConfigFS(s.flags, s.fsys, "broken.conf")
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *FSSuite) TestLoadConfigFS(c *C) {
+	c.Check(LoadConfigFS(s.flags, s.fsys, "missing.conf"), IsNil)
+	c.Check(*s.a, Equals, "flag")
+	c.Assert(LoadConfigFS(s.flags, s.fsys, "defaults/app.conf"), IsNil)
+	c.Check(*s.a, Equals, "default")
+
+	// Only the file itself may be missing.
+	err := LoadConfigFS(s.flags, s.fsys, "broken.conf")
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *FSSuite) TestParserOptions(c *C) {
+	s.fsys["strict.conf"] = &fstest.MapFile{Data: []byte("a = 1\na = 2\n")}
+	p := &Parser{Strict: true}
+	err := p.ParseFS(s.flags, s.fsys, "strict.conf")
+	c.Check(err, ErrorMatches, "strict.conf:2: option `a' set on line 1 and again on line 2")
+	c.Check(p.fsys, IsNil)
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// flagRefPrefix introduces a reference to a flag's current value,
+// as in `${flag:name}'.
+const flagRefPrefix = "flag:"
+
+// An interpolator resolves references of the form `${key}' in the
+// values of a config file to the values of other keys in the same
+// file. References to flags are left alone, to be resolved by
+// expandFlags as each value is set. See Parser.Interpolate.
+type interpolator struct {
+	// values maps each key in the file to its last value, and
+	// last to the index of the entry setting it.
+	values map[string]string
+	last   map[string]int
+	// literal records the keys whose last value is the output of
+	// a command, which is used as is.
+	literal map[string]bool
+	// resolved caches the interpolated values of keys.
+	resolved map[string]string
+	// active is the stack of keys being resolved, to detect
+	// cycles.
+	active []string
+}
+
+func newInterpolator(entries []Entry) *interpolator {
+	in := &interpolator{
+		values:   make(map[string]string),
+		last:     make(map[string]int),
+		literal:  make(map[string]bool),
+		resolved: make(map[string]string),
+	}
+	for i, e := range entries {
+		if e.Key != "" {
+			in.values[e.fullKey()] = e.Value
+			in.last[e.fullKey()] = i
+			in.literal[e.fullKey()] = e.ran
+		}
+	}
+	return in
+}
+
+// interpolate resolves the references in the values of entries, in
+// place.
+func interpolate(name string, entries []Entry) error {
+	in := newInterpolator(entries)
+	for i, e := range entries {
+		if e.Key == "" || e.ran {
+			continue
+		}
+		var value string
+		var err error
+		if key := e.fullKey(); in.last[key] == i {
+			value, err = in.lookup(key)
+		} else {
+			value, err = in.expand(e.Value)
+		}
+		if err != nil {
+			return lineError(name, e.Line, err)
+		}
+		entries[i].Value = value
+	}
+	return nil
+}
+
+// lookup returns the interpolated value of key.
+func (in *interpolator) lookup(key string) (string, error) {
+	if value, ok := in.resolved[key]; ok {
+		return value, nil
+	}
+	raw, ok := in.values[key]
+	if !ok {
+		return "", fmt.Errorf("reference to undefined key `%s'", key)
+	}
+	if in.literal[key] {
+		return raw, nil
+	}
+	for i, k := range in.active {
+		if k == key {
+			cycle := append(in.active[i:len(in.active):len(in.active)], key)
+			return "", fmt.Errorf("reference cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	in.active = append(in.active, key)
+	value, err := in.expand(raw)
+	in.active = in.active[:len(in.active)-1]
+	if err != nil {
+		return "", err
+	}
+	in.resolved[key] = value
+	return value, nil
+}
+
+// expand replaces the references in s with the values they refer
+// to.
+func (in *interpolator) expand(s string) (string, error) {
+	var buf []string
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		j := strings.Index(s[i:], "}")
+		if j < 0 {
+			return "", fmt.Errorf("unterminated reference in `%s'", s)
+		}
+		ref := s[i+2 : i+j]
+		if strings.HasPrefix(ref, flagRefPrefix) {
+			buf = append(buf, s[:i+j+1])
+			s = s[i+j+1:]
+			continue
+		}
+		value, err := in.lookup(ref)
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, s[:i], value)
+		s = s[i+j+1:]
+	}
+	return strings.Join(append(buf, s), ""), nil
+}
+
+// expandFlags replaces the references of the form `${flag:name}' in s
+// with the current values of the flags they name.
+func expandFlags(flags *flag.FlagSet, s string) (string, error) {
+	var buf []string
+	for {
+		i := strings.Index(s, "${"+flagRefPrefix)
+		if i < 0 {
+			break
+		}
+		j := strings.Index(s[i:], "}")
+		if j < 0 {
+			return "", fmt.Errorf("unterminated reference in `%s'", s)
+		}
+		name := s[i+2+len(flagRefPrefix) : i+j]
+		f := flags.Lookup(name)
+		if f == nil {
+			return "", fmt.Errorf("reference to undefined flag `%s'", name)
+		}
+		buf = append(buf, s[:i], f.Value.String())
+		s = s[i+j+1:]
+	}
+	return strings.Join(append(buf, s), ""), nil
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"flag"
+	. "launchpad.net/gocheck"
+	"strings"
+)
+
+type InterpolateSuite struct {
+	flags *flag.FlagSet
+	base  *string
+	data  *string
+	logs  *string
+}
+
+var _ = Suite(&InterpolateSuite{})
+
+func (s *InterpolateSuite) SetUpTest(c *C) {
+	s.flags = flag.NewFlagSet("interpolate", flag.ContinueOnError)
+	s.base = s.flags.String("base", "", "")
+	s.data = s.flags.String("data", "", "")
+	s.logs = s.flags.String("paths.logs", "", "")
+}
+
+func (s *InterpolateSuite) parse(config string) error {
+	p := &Parser{Interpolate: true}
+	return p.Parse(s.flags, strings.NewReader(config))
+}
+
+func (s *InterpolateSuite) TestInterpolate(c *C) {
+	err := s.parse("" +
+		"data = ${base}/data\n" +
+		"base = /opt/app\n" +
+		"[paths]\n" +
+		"logs = ${data}/logs:${base}\n")
+	c.Assert(err, IsNil)
+	c.Check(*s.base, Equals, "/opt/app")
+	c.Check(*s.data, Equals, "/opt/app/data")
+	c.Check(*s.logs, Equals, "/opt/app/data/logs:/opt/app")
+}
+
+func (s *InterpolateSuite) TestLastValueWins(c *C) {
+	err := s.parse("" +
+		"base = /tmp\n" +
+		"data = ${base}\n" +
+		"base = /opt/${paths.logs}\n" +
+		"paths.logs = logs\n")
+	c.Assert(err, IsNil)
+	c.Check(*s.base, Equals, "/opt/logs")
+	c.Check(*s.data, Equals, "/opt/logs")
+}
+
+func (s *InterpolateSuite) TestFlagReferences(c *C) {
+	s.flags.Set("base", "/srv")
+	err := s.parse("" +
+		"data = ${flag:base}/data\n" +
+		"base = /opt/app\n" +
+		"[paths]\n" +
+		"logs = ${flag:base}/logs:${flag:data}\n")
+	c.Assert(err, IsNil)
+	c.Check(*s.data, Equals, "/srv/data")
+	c.Check(*s.logs, Equals, "/opt/app/logs:/srv/data")
+
+	// References to keys are resolved first, and may yield flag
+	// references.
+	err = s.parse("" +
+		"base = ${data}\n" +
+		"data = ${flag:paths.logs}\n")
+	c.Assert(err, IsNil)
+	c.Check(*s.base, Equals, "/opt/app/logs:/srv/data")
+
+	err = s.parse("data = ${flag:bogus}\n")
+	c.Check(err, ErrorMatches, "reference to undefined flag `bogus'")
+}
+
+func (s *InterpolateSuite) TestDisabled(c *C) {
+	err := ParseConfig(s.flags, strings.NewReader("data = ${base}/data\n"))
+	c.Assert(err, IsNil)
+	c.Check(*s.data, Equals, "${base}/data")
+}
+
+func (s *InterpolateSuite) TestErrors(c *C) {
+	testCases := []struct {
+		config string
+		err    string
+	}{
+		{"data = ${nope}\n", "reference to undefined key `nope'"},
+		{"data = ${base\n", "unterminated reference in `\\${base'"},
+		{"base = ${base}\n", "reference cycle: base -> base"},
+		{"" +
+			"base = x${data}\n" +
+			"data = ${paths.logs}\n" +
+			"paths.logs = ${base}\n",
+			"reference cycle: base -> data -> paths.logs -> base"},
+	}
+	for _, tc := range testCases {
+		c.Check(s.parse(tc.config), ErrorMatches, tc.err)
+	}
+
+	p := &Parser{Interpolate: true}
+	err := p.ParseNamed(s.flags, "app.conf", strings.NewReader(""+
+		"base = /opt\n"+
+		"data = ${bsae}/data\n"))
+	c.Check(err, ErrorMatches, "app.conf:2: reference to undefined key `bsae'")
+	c.Check(*s.base, Equals, "")
+}
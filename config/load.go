@@ -0,0 +1,242 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// stdin is the source of config files named "-"; tests replace it.
+var stdin io.Reader = os.Stdin
+
+// stdinName is the name under which config read from the standard
+// input is parsed, for error messages.
+const stdinName = "<stdin>"
+
+// LoadOptions describes the configuration sources consulted by Load.
+type LoadOptions struct {
+	// Defaults is the text of a config file applied before any
+	// other source. It is typically embedded in the program.
+	Defaults string
+	// Paths lists config files to apply, in order. Files that
+	// don't exist are skipped, and "-" denotes the standard input.
+	Paths []string
+	// EnvPrefix, if not empty, is the prefix of environment
+	// variables to apply after all files (see LoadEnv).
+	EnvPrefix string
+}
+
+// Load loads configuration from several sources into a FlagSet. Each
+// source overrides the ones before it, in this order:
+//
+//  1. opts.Defaults
+//  2. each file in opts.Paths, in order
+//  3. environment variables starting with opts.EnvPrefix
+//
+// A typical program lists a system-wide config file and then a
+// per-user one in opts.Paths. Flags given on the command line should
+// be parsed after calling Load, so that they take precedence over
+// all configuration.
+func Load(flags *flag.FlagSet, opts LoadOptions) error {
+	if opts.Defaults != "" {
+		err := ParseConfigNamed(flags, "<defaults>", strings.NewReader(opts.Defaults))
+		if err != nil {
+			return err
+		}
+	}
+	if err := LoadConfigPaths(flags, opts.Paths...); err != nil {
+		return err
+	}
+	if opts.EnvPrefix != "" {
+		return LoadEnv(flags, opts.EnvPrefix)
+	}
+	return nil
+}
+
+// LoadConfigPaths parses each of the named config files, in order,
+// so that values in later files override earlier ones. Files that
+// don't exist are skipped. The path "-" denotes the standard input.
+func LoadConfigPaths(flags *flag.FlagSet, paths ...string) error {
+	_, err := LoadConfigPathsFound(flags, paths...)
+	return err
+}
+
+// LoadConfigPathsFound is like LoadConfigPaths, but also reports
+// whether any of the files existed. This allows a program to tell a
+// first run, with no config anywhere, from one whose config was
+// applied, and offer to create a config file.
+func LoadConfigPathsFound(flags *flag.FlagSet, paths ...string) (found bool, err error) {
+	for _, path := range paths {
+		ok, err := loadPath(flags, path, make(map[string]bool))
+		found = found || ok
+		if err != nil {
+			return found, err
+		}
+	}
+	return found, nil
+}
+
+// A Conflict describes a flag set to different values by two config
+// files loaded by LoadConfigPathsConflicts.
+type Conflict struct {
+	// Key is the name of the flag.
+	Key string
+	// First is the value the flag was first given, and the file
+	// that gave it; Second is a later, different, value and its
+	// file.
+	First, Second Resolved
+}
+
+func (c Conflict) Error() string {
+	return fmt.Sprintf("option `%s' set to `%s' in %s, but to `%s' in %s",
+		c.Key, c.First.Value, c.First.Source, c.Second.Value, c.Second.Source)
+}
+
+// LoadConfigPathsConflicts is like LoadConfigPaths, but calls
+// conflict for each flag that a file sets to a value different from
+// the one an earlier file set it to, for programs that merge several
+// layers of configuration and want to warn about disagreements
+// between them. Values are compared as returned by the flags' String
+// methods, after each file is parsed. Files are still applied in
+// order, so that the last value wins. Flags meant to be set
+// repeatedly (see Parser.Strict), which accumulate values rather
+// than override them, never conflict. A flag reset by an unset
+// directive is treated as not yet set.
+func LoadConfigPathsConflicts(flags *flag.FlagSet, conflict func(Conflict), paths ...string) error {
+	first := make(map[string]Resolved)
+	for _, path := range paths {
+		set := make(map[string]bool)
+		if _, err := loadPath(flags, path, set); err != nil {
+			return err
+		}
+		name := path
+		if path == "-" {
+			name = stdinName
+		}
+		keys := make([]string, 0, len(set))
+		for key := range set {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			f := flags.Lookup(key)
+			if !set[key] || f == nil {
+				delete(first, key)
+				continue
+			}
+			if isRepeatable(f) {
+				continue
+			}
+			value := Resolved{f.Value.String(), name}
+			if prev, seen := first[key]; !seen {
+				first[key] = value
+			} else if prev.Value != value.Value {
+				conflict(Conflict{key, prev, value})
+			}
+		}
+	}
+	return nil
+}
+
+// loadPath parses the named config file, reporting whether it
+// exists, and records the keys it sets in set, as for Parser.parse.
+func loadPath(flags *flag.FlagSet, path string, set map[string]bool) (bool, error) {
+	if path == "-" {
+		return true, new(Parser).parse(flags, stdinName, stdin, 0, set)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+	return true, new(Parser).parse(flags, path, f, 0, set)
+}
+
+// A NamedReader is a config file to be parsed by ResolveConfigs.
+type NamedReader struct {
+	// Name identifies the file in errors and in the results of
+	// ResolveConfigs.
+	Name string
+	io.Reader
+}
+
+// Resolved describes the final value of a flag after ResolveConfigs.
+type Resolved struct {
+	// Value is the value of the flag, as returned by its String
+	// method.
+	Value string
+	// Source is the name of the config file that last set the
+	// flag, or empty if none did and the flag has its default
+	// value.
+	Source string
+}
+
+// ResolveConfigs parses each of the config files in sources, in
+// order, like LoadConfigPaths, and reports the final value of every
+// flag along with the file it came from. This makes it possible to
+// check the precedence of several layers of configuration directly,
+// such as in a test that a per-user file overrides a system-wide
+// one. A flag reset to its default by an unset directive has no
+// source.
+func ResolveConfigs(flags *flag.FlagSet, sources ...NamedReader) (map[string]Resolved, error) {
+	from := make(map[string]string)
+	for _, src := range sources {
+		set := make(map[string]bool)
+		if err := new(Parser).parse(flags, src.Name, src, 0, set); err != nil {
+			return nil, err
+		}
+		for key, ok := range set {
+			if ok {
+				from[key] = src.Name
+			} else {
+				delete(from, key)
+			}
+		}
+	}
+
+	resolved := make(map[string]Resolved)
+	flags.VisitAll(func(f *flag.Flag) {
+		resolved[f.Name] = Resolved{f.Value.String(), from[f.Name]}
+	})
+	return resolved, nil
+}
+
+// LoadEnv sets flags from environment variables. The variable for a
+// flag is named by the prefix, an underscore, and the flag's name in
+// upper case, with any character other than a letter or digit
+// replaced by an underscore. For example, with the prefix "MYAPP",
+// the flag "log-file" is set from $MYAPP_LOG_FILE. Unset variables
+// are ignored.
+func LoadEnv(flags *flag.FlagSet, prefix string) error {
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		name := envName(prefix, f.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			if e := flags.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("%s: %v", name, e)
+			}
+		}
+	})
+	return err
+}
+
+func envName(prefix, key string) string {
+	return prefix + "_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		}
+		return '_'
+	}, key)
+}
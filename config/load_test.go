@@ -0,0 +1,181 @@
+package config
+
+import (
+	"flag"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type LoadSuite struct {
+	flags *flag.FlagSet
+	a     *string
+	b     *string
+	c     *string
+	d     *string
+}
+
+var _ = Suite(&LoadSuite{})
+
+func (s *LoadSuite) SetUpTest(c *C) {
+	s.flags = flag.NewFlagSet("testSuite", flag.ContinueOnError)
+	s.a = s.flags.String("a", "flag", "")
+	s.b = s.flags.String("b", "flag", "")
+	s.c = s.flags.String("c", "flag", "")
+	s.d = s.flags.String("log-file", "flag", "")
+}
+
+func (s *LoadSuite) TestLoad(c *C) {
+	dir := c.MkDir()
+	system := filepath.Join(dir, "system.conf")
+	user := filepath.Join(dir, "user.conf")
+	writeFile(c, system, "a = system\nb = system\nc = system\n")
+	writeFile(c, user, "b = user\nc = user\n")
+
+	os.Setenv("LOADTEST_C", "env")
+	defer os.Unsetenv("LOADTEST_C")
+
+	err := Load(s.flags, LoadOptions{
+		Defaults:  "a = default\nlog-file = default\n",
+		Paths:     []string{system, filepath.Join(dir, "missing.conf"), user},
+		EnvPrefix: "LOADTEST",
+	})
+	c.Assert(err, IsNil)
+	c.Check(*s.a, Equals, "system")
+	c.Check(*s.b, Equals, "user")
+	c.Check(*s.c, Equals, "env")
+	c.Check(*s.d, Equals, "default")
+}
+
+func (s *LoadSuite) TestLoadErrors(c *C) {
+	err := Load(s.flags, LoadOptions{Defaults: "a = 1\nbogus = 2\n"})
+	c.Check(err, ErrorMatches, "<defaults>:2: unknown option `bogus'")
+
+	dir := c.MkDir()
+	path := filepath.Join(dir, "bad.conf")
+	writeFile(c, path, "bogus\n")
+	err = LoadConfigPaths(s.flags, path)
+	c.Check(err, ErrorMatches, ".*bad.conf:1: illegal config line: `bogus'")
+}
+
+func (s *LoadSuite) TestLoadConfigPathsFound(c *C) {
+	dir := c.MkDir()
+	missing := filepath.Join(dir, "missing.conf")
+	found, err := LoadConfigPathsFound(s.flags, missing, filepath.Join(dir, "also-missing.conf"))
+	c.Check(err, IsNil)
+	c.Check(found, Equals, false)
+
+	path := filepath.Join(dir, "app.conf")
+	writeFile(c, path, "a = file\n")
+	found, err = LoadConfigPathsFound(s.flags, missing, path)
+	c.Check(err, IsNil)
+	c.Check(found, Equals, true)
+	c.Check(*s.a, Equals, "file")
+
+	writeFile(c, path, "bogus = 1\n")
+	found, err = LoadConfigPathsFound(s.flags, path)
+	c.Check(err, ErrorMatches, ".*app.conf:1: unknown option `bogus'")
+	c.Check(found, Equals, true)
+}
+
+func (s *LoadSuite) TestLoadConfigPathsConflicts(c *C) {
+	var list listValue
+	s.flags.Var(&list, "list", "")
+	dir := c.MkDir()
+	system := filepath.Join(dir, "system.conf")
+	user := filepath.Join(dir, "user.conf")
+	local := filepath.Join(dir, "local.conf")
+	writeFile(c, system, "a = system\nb = same\nc = system\nlist = x\n")
+	writeFile(c, user, "a = user\nb = same\nunset c\nlist = y\n")
+	writeFile(c, local, "a = local\nc = local\nlog-file = local\n")
+
+	var conflicts []Conflict
+	err := LoadConfigPathsConflicts(s.flags, func(conflict Conflict) {
+		conflicts = append(conflicts, conflict)
+	}, system, filepath.Join(dir, "missing.conf"), user, local)
+	c.Assert(err, IsNil)
+	c.Check(conflicts, DeepEquals, []Conflict{
+		{"a", Resolved{"system", system}, Resolved{"user", user}},
+		{"a", Resolved{"system", system}, Resolved{"local", local}},
+	})
+	c.Check(conflicts[0], ErrorMatches, "option `a' set to `system' in .*system.conf, but to `user' in .*user.conf")
+	c.Check(*s.a, Equals, "local")
+	c.Check(*s.c, Equals, "local")
+	c.Check([]string(list), DeepEquals, []string{"x", "y"})
+
+	writeFile(c, local, "bogus = 1\n")
+	err = LoadConfigPathsConflicts(s.flags, func(Conflict) {}, local)
+	c.Check(err, ErrorMatches, ".*local.conf:1: unknown option `bogus'")
+}
+
+func (s *LoadSuite) TestResolveConfigs(c *C) {
+	resolved, err := ResolveConfigs(s.flags,
+		NamedReader{"defaults", strings.NewReader("a = default\nb = default\nlog-file = default\n")},
+		NamedReader{"system", strings.NewReader("b = system\nc = system\n")},
+		NamedReader{"user", strings.NewReader("c = user\nunset log-file\n")})
+	c.Assert(err, IsNil)
+	c.Check(resolved, DeepEquals, map[string]Resolved{
+		"a":        {"default", "defaults"},
+		"b":        {"system", "system"},
+		"c":        {"user", "user"},
+		"log-file": {"flag", ""},
+	})
+
+	_, err = ResolveConfigs(s.flags,
+		NamedReader{"system", strings.NewReader("a = 1\n")},
+		NamedReader{"user", strings.NewReader("bogus = 1\n")})
+	c.Check(err, ErrorMatches, "user:1: unknown option `bogus'")
+}
+
+func (s *LoadSuite) TestLoadEnv(c *C) {
+	os.Setenv("LOADTEST_LOG_FILE", "/var/log/x")
+	defer os.Unsetenv("LOADTEST_LOG_FILE")
+	c.Assert(LoadEnv(s.flags, "LOADTEST"), IsNil)
+	c.Check(*s.d, Equals, "/var/log/x")
+	c.Check(*s.a, Equals, "flag")
+
+	c.Check(envName("APP", "log.path-2"), Equals, "APP_LOG_PATH_2")
+}
+
+func (s *LoadSuite) TestLoadConfig(c *C) {
+	home := os.Getenv("HOME")
+	defer os.Setenv("HOME", home)
+
+	dir := c.MkDir()
+	os.Setenv("HOME", dir)
+	c.Assert(LoadConfig(s.flags, "apprc"), IsNil)
+	c.Check(*s.a, Equals, "flag")
+
+	writeFile(c, filepath.Join(dir, ".apprc"), "a = home\n")
+	c.Assert(LoadConfig(s.flags, "apprc"), IsNil)
+	c.Check(*s.a, Equals, "home")
+
+	os.Unsetenv("HOME")
+	c.Check(LoadConfig(s.flags, "apprc"), ErrorMatches, "cannot locate config file .apprc: .*")
+}
+
+func (s *LoadSuite) TestStdin(c *C) {
+	defer func() { stdin = os.Stdin }()
+	dir := c.MkDir()
+	path := filepath.Join(dir, "app.conf")
+	writeFile(c, path, "a = file\nb = file\n")
+
+	stdin = strings.NewReader("b = stdin\nc = stdin\n")
+	c.Assert(LoadConfigPaths(s.flags, path, "-"), IsNil)
+	c.Check(*s.a, Equals, "file")
+	c.Check(*s.b, Equals, "stdin")
+	c.Check(*s.c, Equals, "stdin")
+
+	stdin = strings.NewReader("a = piped\n")
+	c.Assert(LoadConfig(s.flags, "-"), IsNil)
+	c.Check(*s.a, Equals, "piped")
+
+	stdin = strings.NewReader("b = auto\n")
+	c.Assert(LoadAuto(s.flags, "-"), IsNil)
+	c.Check(*s.b, Equals, "auto")
+
+	stdin = strings.NewReader("bogus = 1\n")
+	err := Load(s.flags, LoadOptions{Paths: []string{"-"}})
+	c.Check(err, ErrorMatches, "<stdin>:1: unknown option `bogus'")
+}
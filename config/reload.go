@@ -0,0 +1,75 @@
+package config
+
+import (
+	"flag"
+)
+
+// A Change records the values of a flag before and after a reload.
+type Change struct {
+	Old, New string
+}
+
+// A Reloader reapplies configuration to a FlagSet, for long-running
+// programs that pick up changes to their config files without
+// restarting, typically on SIGHUP. For example:
+//
+//	r := &config.Reloader{
+//		Flags:   flag.CommandLine,
+//		Options: opts,
+//		OnChange: func(changed map[string]config.Change) {
+//			if _, ok := changed["log.path"]; ok {
+//				reopenLog()
+//			}
+//		},
+//	}
+//
+// A Reloader doesn't synchronize with other users of the flags; the
+// program must not read them while a reload is in progress.
+type Reloader struct {
+	// Flags is the FlagSet to reload.
+	Flags *flag.FlagSet
+	// Options describes the configuration sources, as for Load.
+	Options LoadOptions
+	// OnChange, if not nil, is called after a reload that changed
+	// the values of any flags, with the changes keyed by flag name.
+	// It allows a program to act on just what changed, such as by
+	// reopening a log file only if its path did.
+	OnChange func(changed map[string]Change)
+}
+
+// Reload loads the configuration described by r.Options into r.Flags,
+// and returns the flags whose values changed, as given by their
+// String methods.
+//
+// A reload only adds and overrides values: flags aren't reset to
+// their defaults first. A key removed from a config file leaves its
+// flag with the value it had, and a flag whose value is repeatable
+// (see Parser.Strict), such as one accumulating a list, has the
+// reloaded values appended to its old ones. A program whose config
+// removes keys, or sets repeatable flags, should restart instead.
+//
+// If loading fails, the flags may have been partly updated; the
+// error is returned, with the changes made before it, and OnChange
+// isn't called.
+func (r *Reloader) Reload() (map[string]Change, error) {
+	before := make(map[string]string)
+	r.Flags.VisitAll(func(f *flag.Flag) {
+		before[f.Name] = f.Value.String()
+	})
+
+	err := Load(r.Flags, r.Options)
+
+	changed := make(map[string]Change)
+	r.Flags.VisitAll(func(f *flag.Flag) {
+		if value := f.Value.String(); value != before[f.Name] {
+			changed[f.Name] = Change{before[f.Name], value}
+		}
+	})
+	if err != nil {
+		return changed, err
+	}
+	if len(changed) > 0 && r.OnChange != nil {
+		r.OnChange(changed)
+	}
+	return changed, nil
+}
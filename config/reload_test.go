@@ -0,0 +1,83 @@
+package config
+
+import (
+	"flag"
+	. "launchpad.net/gocheck"
+	"path/filepath"
+)
+
+type ReloadSuite struct {
+	flags *flag.FlagSet
+	path  string
+}
+
+var _ = Suite(&ReloadSuite{})
+
+func (s *ReloadSuite) SetUpTest(c *C) {
+	s.flags = flag.NewFlagSet("reload", flag.ContinueOnError)
+	s.flags.String("log.path", "app.log", "")
+	s.flags.Int("workers", 1, "")
+	s.flags.Bool("verbose", false, "")
+	s.path = filepath.Join(c.MkDir(), "app.conf")
+}
+
+func (s *ReloadSuite) TestReload(c *C) {
+	var calls []map[string]Change
+	r := &Reloader{
+		Flags:    s.flags,
+		Options:  LoadOptions{Paths: []string{s.path}},
+		OnChange: func(changed map[string]Change) { calls = append(calls, changed) },
+	}
+
+	writeFile(c, s.path, "workers = 4\nverbose = false\n")
+	changed, err := r.Reload()
+	c.Assert(err, IsNil)
+	c.Check(changed, DeepEquals, map[string]Change{"workers": {"1", "4"}})
+	c.Check(calls, DeepEquals, []map[string]Change{changed})
+
+	writeFile(c, s.path, "workers = 4\nverbose = false\n")
+	changed, err = r.Reload()
+	c.Assert(err, IsNil)
+	c.Check(changed, HasLen, 0)
+	c.Check(calls, HasLen, 1)
+
+	writeFile(c, s.path, "log.path = /var/log/app.log\nverbose = true\n")
+	changed, err = r.Reload()
+	c.Assert(err, IsNil)
+	c.Check(changed, DeepEquals, map[string]Change{
+		"log.path": {"app.log", "/var/log/app.log"},
+		"verbose":  {"false", "true"},
+	})
+	c.Check(calls, HasLen, 2)
+	c.Check(s.flags.Lookup("workers").Value.String(), Equals, "4")
+}
+
+func (s *ReloadSuite) TestReloadOnlyAddsAndOverrides(c *C) {
+	tags := new(listValue)
+	s.flags.Var(tags, "tag", "")
+	r := &Reloader{Flags: s.flags, Options: LoadOptions{Paths: []string{s.path}}}
+
+	writeFile(c, s.path, "workers = 4\ntag = a\n")
+	_, err := r.Reload()
+	c.Assert(err, IsNil)
+
+	writeFile(c, s.path, "tag = a\n")
+	changed, err := r.Reload()
+	c.Assert(err, IsNil)
+	c.Check(changed, DeepEquals, map[string]Change{"tag": {"a", "a,a"}})
+	c.Check(s.flags.Lookup("workers").Value.String(), Equals, "4")
+}
+
+func (s *ReloadSuite) TestReloadError(c *C) {
+	called := false
+	r := &Reloader{
+		Flags:    s.flags,
+		Options:  LoadOptions{Paths: []string{s.path}},
+		OnChange: func(map[string]Change) { called = true },
+	}
+	writeFile(c, s.path, "workers = 2\nworkers = many\n")
+	changed, err := r.Reload()
+	c.Check(err, ErrorMatches, ".*app.conf:2: invalid value `many' for option `workers': expected an integer")
+	c.Check(changed["workers"].Old, Equals, "1")
+	c.Check(called, Equals, false)
+}
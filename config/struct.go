@@ -0,0 +1,178 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// LoadInto parses a config file into a struct, for programs that
+// don't define their configuration using the flag package. v must be
+// a pointer to a struct. Fields are mapped to keys using `config'
+// struct tags:
+//
+//	type Config struct {
+//		Port    int           `config:"port"`
+//		Timeout time.Duration `config:"timeout"`
+//		Server  struct {
+//			Host string `config:"host"`
+//		} `config:"server"`
+//	}
+//
+// Fields without a tag are ignored. A tagged field of struct type
+// corresponds to a section of the config file, so that the key
+// `host' in the section `[server]' sets Server.Host above. Fields may
+// be of any integer, floating-point, string or boolean type, a
+// time.Duration, or of a type implementing flag.Value by pointer.
+//
+// Otherwise, LoadInto behaves exactly like ParseConfig, including its
+// error messages for unknown keys and malformed values.
+func LoadInto(v interface{}, r io.Reader) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: LoadInto requires a pointer to a struct, not %T", v)
+	}
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	if err := defineStructFlags(flags, "", rv.Elem()); err != nil {
+		return err
+	}
+	return ParseConfig(flags, r)
+}
+
+var (
+	durationType  = reflect.TypeOf(time.Duration(0))
+	flagValueType = reflect.TypeOf((*flag.Value)(nil)).Elem()
+)
+
+// defineStructFlags defines a flag for each tagged field of a struct,
+// storing its value into the field.
+func defineStructFlags(flags *flag.FlagSet, prefix string, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		key := sf.Tag.Get("config")
+		if key == "" {
+			continue
+		}
+		key = prefix + key
+		field := rv.Field(i)
+		if !field.CanSet() {
+			return fmt.Errorf("config: field %s of %s is not exported", sf.Name, rt)
+		}
+
+		if field.Addr().Type().Implements(flagValueType) {
+			flags.Var(field.Addr().Interface().(flag.Value), key, "")
+			continue
+		}
+		if sf.Type == durationType {
+			p := field.Addr().Interface().(*time.Duration)
+			flags.DurationVar(p, key, *p, "")
+			continue
+		}
+
+		switch p := field.Addr().Interface().(type) {
+		case *string:
+			flags.StringVar(p, key, *p, "")
+		case *bool:
+			flags.BoolVar(p, key, *p, "")
+		case *int:
+			flags.IntVar(p, key, *p, "")
+		case *int64:
+			flags.Int64Var(p, key, *p, "")
+		case *uint:
+			flags.UintVar(p, key, *p, "")
+		case *uint64:
+			flags.Uint64Var(p, key, *p, "")
+		case *float64:
+			flags.Float64Var(p, key, *p, "")
+		default:
+			switch field.Kind() {
+			case reflect.Struct:
+				if err := defineStructFlags(flags, key+".", field); err != nil {
+					return err
+				}
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Float32, reflect.Float64, reflect.String, reflect.Bool:
+				flags.Var(reflectValue{field}, key, "")
+			default:
+				return fmt.Errorf("config: field %s of %s has unsupported type %s", sf.Name, rt, sf.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// reflectValue is a flag.Value that sets a field of a basic kind
+// not covered by the flag package, such as int32 or a named string
+// type.
+type reflectValue struct {
+	v reflect.Value
+}
+
+// These match the errors returned by the flag package's own values.
+var (
+	errParse = errors.New("parse error")
+	errRange = errors.New("value out of range")
+)
+
+func numError(err error) error {
+	if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+		return errRange
+	}
+	return errParse
+}
+
+func (r reflectValue) String() string {
+	if !r.v.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(r.v.Interface())
+}
+
+func (r reflectValue) Set(s string) error {
+	switch r.v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 0, r.v.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		r.v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 0, r.v.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		r.v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, r.v.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		r.v.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errParse
+		}
+		r.v.SetBool(b)
+	case reflect.String:
+		r.v.SetString(s)
+	}
+	return nil
+}
+
+func (r reflectValue) Get() interface{} {
+	if !r.v.IsValid() {
+		return nil
+	}
+	return r.v.Interface()
+}
+
+func (r reflectValue) IsBoolFlag() bool {
+	return r.v.IsValid() && r.v.Kind() == reflect.Bool
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	. "launchpad.net/gocheck"
+	"strings"
+	"time"
+)
+
+type StructSuite struct{}
+
+var _ = Suite(&StructSuite{})
+
+type level string
+
+type testConfig struct {
+	Port    int           `config:"port"`
+	Small   int8          `config:"small"`
+	Ratio   float32       `config:"ratio"`
+	Verbose bool          `config:"verbose"`
+	Timeout time.Duration `config:"timeout"`
+	Level   level         `config:"level"`
+	List    listValue     `config:"list"`
+	Ignored string
+	Server  struct {
+		Host string `config:"host"`
+		Port uint16 `config:"port"`
+	} `config:"server"`
+}
+
+func (s *StructSuite) TestLoadInto(c *C) {
+	cfg := testConfig{Port: 80, Ignored: "x"}
+	err := LoadInto(&cfg, strings.NewReader(""+
+		"small = -3\n"+
+		"ratio = 0.5\n"+
+		"verbose = true\n"+
+		"timeout = 1m30s\n"+
+		"level = debug\n"+
+		"list = a\n"+
+		"list = b\n"+
+		"[server]\n"+
+		"host = example.com\n"+
+		"port = 8080\n"))
+	c.Assert(err, IsNil)
+	c.Check(cfg.Port, Equals, 80)
+	c.Check(cfg.Small, Equals, int8(-3))
+	c.Check(cfg.Ratio, Equals, float32(0.5))
+	c.Check(cfg.Verbose, Equals, true)
+	c.Check(cfg.Timeout, Equals, 90*time.Second)
+	c.Check(cfg.Level, Equals, level("debug"))
+	c.Check([]string(cfg.List), DeepEquals, []string{"a", "b"})
+	c.Check(cfg.Ignored, Equals, "x")
+	c.Check(cfg.Server.Host, Equals, "example.com")
+	c.Check(cfg.Server.Port, Equals, uint16(8080))
+}
+
+func (s *StructSuite) TestLoadIntoErrors(c *C) {
+	var cfg testConfig
+	err := LoadInto(&cfg, strings.NewReader("bogus = 1\n"))
+	c.Check(err, ErrorMatches, "unknown option `bogus'")
+	err = LoadInto(&cfg, strings.NewReader("port = eighty\n"))
+	c.Check(err, ErrorMatches, "invalid value `eighty' for option `port': expected an integer")
+	err = LoadInto(&cfg, strings.NewReader("small = 300\n"))
+	c.Check(err, ErrorMatches, "value `300' out of range for option `small'")
+	err = LoadInto(&cfg, strings.NewReader("[server]\nport = -1\n"))
+	c.Check(err, ErrorMatches, "invalid value `-1' for option `server.port': expected a non-negative integer")
+
+	err = LoadInto(cfg, strings.NewReader(""))
+	c.Check(err, ErrorMatches, "config: LoadInto requires a pointer to a struct.*")
+
+	var bad struct {
+		Ch chan int `config:"ch"`
+	}
+	err = LoadInto(&bad, strings.NewReader(""))
+	c.Check(err, ErrorMatches, "config: field Ch .* has unsupported type chan int")
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// WriteConfig writes the current values of all the flags in a
+// FlagSet to w as a config file, one `key = value' line per flag, in
+// lexical order. Values that would not survive being parsed back
+// verbatim, such as ones with leading or trailing whitespace, are
+// written quoted, so the file must be read back by a Parser with
+// Quoted set, which then restores every flag to the value it had.
+func WriteConfig(flags *flag.FlagSet, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	flags.VisitAll(func(f *flag.Flag) {
+		bw.WriteString(f.Name + " = " + formatValue(f.Value.String()) + "\n")
+	})
+	return bw.Flush()
+}
+
+// SaveConfig writes the current values of the flags in a FlagSet to
+// the named file, as for WriteConfig, creating or truncating it.
+func SaveConfig(flags *flag.FlagSet, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteConfig(flags, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// formatValue returns the representation of a value in a config
+// file, quoting it if necessary.
+func formatValue(value string) string {
+	if needsQuoting(value) {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// needsQuoting reports whether a value must be quoted to be parsed
+// back exactly: if it has leading or trailing whitespace, which the
+// parser trims, starts with a quote, or contains a `#', `=' or
+// control character such as a newline.
+func needsQuoting(value string) bool {
+	// A value of the form `$(command)' is run by Parser.AllowExec
+	// unless it is quoted.
+	if value != strings.TrimSpace(value) || strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "$(") {
+		return true
+	}
+	for _, r := range value {
+		if r == '#' || r == '=' || unicode.IsControl(r) {
+			return true
+		}
+	}
+	return false
+}
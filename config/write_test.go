@@ -0,0 +1,100 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type WriteSuite struct{}
+
+var _ = Suite(&WriteSuite{})
+
+func (s *WriteSuite) TestNeedsQuoting(c *C) {
+	testCases := []struct {
+		value string
+		quote bool
+	}{
+		{"hello world", false},
+		{"", false},
+		{`C:\temp\`, false},
+		{" leading", true},
+		{"trailing\t", true},
+		{"a # b", true},
+		{"a=b", true},
+		{"two\nlines", true},
+		{"cr\r", true},
+		{`"quoted"`, true},
+		{`say "hi"`, false},
+		{"$(echo run)", true},
+		{"cost: $(5)", false},
+	}
+	for _, tc := range testCases {
+		c.Check(needsQuoting(tc.value), Equals, tc.quote, Commentf("value: %q", tc.value))
+	}
+}
+
+func (s *WriteSuite) TestRoundTrip(c *C) {
+	values := []string{
+		"plain",
+		"",
+		"  padded  ",
+		"# not a comment",
+		"key=value",
+		"first\nsecond\n",
+		`"already quoted"`,
+		`C:\temp\`,
+		"tab\tinside",
+		"unicode ☃",
+	}
+	flags := flag.NewFlagSet("write", flag.ContinueOnError)
+	for i, v := range values {
+		flags.String(string(rune('a'+i)), v, "")
+	}
+	flags.String("sec.key", "x", "")
+
+	var buf bytes.Buffer
+	c.Assert(WriteConfig(flags, &buf), IsNil)
+	c.Check(strings.Contains(buf.String(), "a = plain\n"), Equals, true)
+	c.Check(strings.Contains(buf.String(), `c = "  padded  "`+"\n"), Equals, true)
+
+	parsed := flag.NewFlagSet("parse", flag.ContinueOnError)
+	for i := range values {
+		parsed.String(string(rune('a'+i)), "default", "")
+	}
+	parsed.String("sec.key", "", "")
+	c.Assert((&Parser{Quoted: true}).Parse(parsed, &buf), IsNil)
+	flags.VisitAll(func(f *flag.Flag) {
+		c.Check(parsed.Lookup(f.Name).Value.String(), Equals, f.Value.String(),
+			Commentf("flag: %s", f.Name))
+	})
+}
+
+func (s *WriteSuite) TestRoundTripCommand(c *C) {
+	flags := flag.NewFlagSet("write", flag.ContinueOnError)
+	flags.String("password", "$(echo ran)", "")
+	var buf bytes.Buffer
+	c.Assert(WriteConfig(flags, &buf), IsNil)
+
+	parsed := flag.NewFlagSet("parse", flag.ContinueOnError)
+	password := parsed.String("password", "", "")
+	c.Assert((&Parser{Quoted: true, AllowExec: true}).Parse(parsed, &buf), IsNil)
+	c.Check(*password, Equals, "$(echo ran)")
+}
+
+func (s *WriteSuite) TestSaveConfig(c *C) {
+	flags := flag.NewFlagSet("save", flag.ContinueOnError)
+	name := flags.String("name", " spaced ", "")
+	path := filepath.Join(c.MkDir(), "app.conf")
+	c.Assert(SaveConfig(flags, path), IsNil)
+
+	*name = ""
+	f, err := os.Open(path)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	c.Assert((&Parser{Quoted: true}).ParseNamed(flags, path, f), IsNil)
+	c.Check(*name, Equals, " spaced ")
+}